@@ -0,0 +1,58 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Level is what a user can do in a space, each level includes the ones before it
+type Level int
+
+const (
+	None Level = iota
+	// Read lists, downloads, stats and hashes files
+	Read
+	// Create uploads new files, but can not replace existing ones
+	Create
+	// Write replaces existing files too
+	Write
+	// Delete is for removing files too. No endpoint removes files yet, so for now it allows the same as Write.
+	Delete
+	// Admin is for managing the space too. No endpoint needs it yet, so for now it allows the same as Write.
+	Admin
+)
+
+// DefaultLevel is the level of spaces listed without one, which is what having a space meant before levels
+const DefaultLevel = Write
+
+var levelNames = []string{"none", "read", "create", "write", "delete", "admin"}
+
+func (l Level) String() string {
+	if l < None || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+func ParseLevel(levelStr string) (Level, error) {
+	for i, name := range levelNames {
+		if name == levelStr {
+			return Level(i), nil
+		}
+	}
+	return None, fmt.Errorf("unknown access level '%s', should be one of %s", levelStr, strings.Join(levelNames[1:], ", "))
+}
+
+// ParseSpaceAccess parses 'space' or 'space:level' of user spaces in the config
+func ParseSpaceAccess(raw string) (string, Level, error) {
+	spaceName, levelStr, found := strings.Cut(raw, ":")
+	if !found {
+		return spaceName, DefaultLevel, nil
+	}
+
+	level, err := ParseLevel(levelStr)
+	if err != nil {
+		return "", None, err
+	}
+	return spaceName, level, nil
+}
@@ -0,0 +1,42 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type parseSpaceAccessTestCase struct {
+	Name  string
+	Raw   string
+	Space string
+	Level Level
+	Err   bool
+}
+
+func TestParseSpaceAccess(t *testing.T) {
+	testCases := []parseSpaceAccessTestCase{
+		{Name: "noLevel", Raw: "music", Space: "music", Level: DefaultLevel},
+		{Name: "read", Raw: "music:read", Space: "music", Level: Read},
+		{Name: "admin", Raw: "music:admin", Space: "music", Level: Admin},
+		{Name: "unknownLevel", Raw: "music:everything", Err: true},
+		{Name: "none", Raw: "music:none", Space: "music", Level: None},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			space, level, err := ParseSpaceAccess(tc.Raw)
+			if tc.Err {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, space, tc.Space)
+			assert.Equal(t, level, tc.Level)
+		})
+	}
+
+	assert.True(t, Read < Create && Create < Write && Write < Delete && Delete < Admin)
+	assert.Equal(t, Write.String(), "write")
+}
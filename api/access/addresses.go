@@ -0,0 +1,80 @@
+package access
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// AddressRules decide which addresses a user can authenticate from.
+// Deny wins over Allow, and an empty Allow allows every address which is not denied.
+type AddressRules struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// ParseAddressRules parses CIDRs like '10.0.0.0/8', or single addresses like '10.1.2.3'
+func ParseAddressRules(allow, deny []string) (AddressRules, error) {
+	var rules AddressRules
+	var err error
+	if rules.Allow, err = parseNetworks(allow); err != nil {
+		return AddressRules{}, err
+	}
+	if rules.Deny, err = parseNetworks(deny); err != nil {
+		return AddressRules{}, err
+	}
+	return rules, nil
+}
+
+// IsEmpty reports whether there are no rules, which allows every address
+func (a AddressRules) IsEmpty() bool {
+	return len(a.Allow) == 0 && len(a.Deny) == 0
+}
+
+func (a AddressRules) Allows(ip net.IP) bool {
+	if ip == nil {
+		return a.IsEmpty()
+	}
+
+	for _, network := range a.Deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(a.Allow) == 0 {
+		return true
+	}
+	for _, network := range a.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNetworks(rawNetworks []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(rawNetworks))
+	for _, raw := range rawNetworks {
+		raw = strings.TrimSpace(raw)
+		if !strings.Contains(raw, "/") {
+			ip := net.ParseIP(raw)
+			if ip == nil {
+				return nil, fmt.Errorf("bad address '%s'", raw)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad network '%s'", raw)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
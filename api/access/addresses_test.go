@@ -0,0 +1,42 @@
+package access
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type addressRulesTestCase struct {
+	Name    string
+	Allow   []string
+	Deny    []string
+	IP      string
+	Allowed bool
+}
+
+func TestAddressRules(t *testing.T) {
+	ci := []string{"10.8.0.0/16", "2001:db8::/32"}
+	testCases := []addressRulesTestCase{
+		{Name: "noRules", IP: "1.2.3.4", Allowed: true},
+		{Name: "allowed", Allow: ci, IP: "10.8.1.2", Allowed: true},
+		{Name: "allowedIPv6", Allow: ci, IP: "2001:db8::1", Allowed: true},
+		{Name: "notAllowed", Allow: ci, IP: "10.9.1.2", Allowed: false},
+		{Name: "denied", Allow: ci, Deny: []string{"10.8.1.2"}, IP: "10.8.1.2", Allowed: false},
+		{Name: "onlyDeny", Deny: []string{"192.168.0.0/16"}, IP: "10.8.1.2", Allowed: true},
+		{Name: "onlyDenyDenied", Deny: []string{"192.168.0.0/16"}, IP: "192.168.1.1", Allowed: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			rules, err := ParseAddressRules(tc.Allow, tc.Deny)
+			assert.Nil(t, err)
+			assert.Equal(t, rules.Allows(net.ParseIP(tc.IP)), tc.Allowed)
+		})
+	}
+
+	_, err := ParseAddressRules([]string{"10.8.0.0/33"}, nil)
+	assert.NotNil(t, err)
+	_, err = ParseAddressRules(nil, []string{"ci.example.com"})
+	assert.NotNil(t, err)
+}
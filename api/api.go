@@ -3,19 +3,25 @@ package api
 import (
 	"net/http"
 
+	"github.com/aigic8/gosyn/api/audit"
 	"github.com/aigic8/gosyn/api/handlers"
 	"github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/metrics"
+	"github.com/aigic8/gosyn/api/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/quic-go/quic-go"
-	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/exp/slog"
 )
 
-func Router(spaces map[string]string, users map[string]utils.UserInfo) *chi.Mux {
+// Router builds the API. serverMetrics can be nil, then no metrics are collected. They are served by AdminRouter.
+// tracer can be nil too, then requests are not traced.
+func Router(spaces map[string]string, spaceOptions map[string]utils.SpaceOptions, users map[string]utils.UserInfo, authOptions utils.AuthOptions, limits utils.Limits, auditLogger audit.Logger, serverMetrics *metrics.Server, tracer *tracing.Tracer) *chi.Mux {
 	r := chi.NewRouter()
 
 	// r.Use(middleware.AllowContentType("application/json"))
-	r.Use(middleware.Logger)
+	r.Use(utils.TracingMiddleware(tracer))
+	r.Use(utils.RequestLogMiddleware(slog.Default()))
+	r.Use(utils.MetricsMiddleware(serverMetrics))
 	r.Use(middleware.CleanPath)
 	r.Use(middleware.Recoverer)
 
@@ -27,36 +33,59 @@ func Router(spaces map[string]string, users map[string]utils.UserInfo) *chi.Mux
 		utils.WriteAPIErr(w, http.StatusNotFound, "method not allowed")
 	})
 
-	r.Use(utils.UserAuthMiddleware(users))
+	// anyone can reach the API, so the names of spaces which are not ready are only listed on the admin listener
+	healthHandler := handlers.NewHealthHandler(spaces, false)
+	r.Get("/healthz", healthHandler.Healthz)
+	r.Get("/readyz", healthHandler.Readyz)
 
-	dirHandler := handlers.DirHandler{Spaces: spaces}
-	r.Route("/api/dirs", func(r chi.Router) {
-		r.Get("/list", dirHandler.GetList)
-		r.Get("/tree", dirHandler.GetTree)
-	})
+	authHandler := handlers.AuthHandler{Users: users, CertUsers: authOptions.CertUsers, Signer: authOptions.Signer, Guard: authOptions.Guard, Addresses: authOptions.Addresses}
+	r.Post("/api/auth/login", authHandler.Login)
 
-	fileHandler := handlers.FileHandler{Spaces: spaces}
-	r.Route("/api/files", func(r chi.Router) {
-		r.Get("/", fileHandler.Get)
-		r.Put("/new", fileHandler.PutNew)
-		r.Get("/matches", fileHandler.Match)
-		r.Get("/stat", fileHandler.Stat)
-	})
+	r.Group(func(r chi.Router) {
+		r.Use(utils.UserAuthMiddleware(users, authOptions))
+		r.Use(utils.AuditMiddleware(auditLogger))
+
+		dirHandler := handlers.DirHandler{Spaces: spaces, SpaceOptions: spaceOptions}
+		r.Route("/api/dirs", func(r chi.Router) {
+			r.Get("/list", dirHandler.GetList)
+			r.Get("/tree", dirHandler.GetTree)
+		})
+
+		fileHandler := handlers.FileHandler{Spaces: spaces, SpaceOptions: spaceOptions, Limits: limits}
+		r.Route("/api/files", func(r chi.Router) {
+			r.Get("/", fileHandler.Get)
+			r.Put("/new", fileHandler.PutNew)
+			r.Get("/matches", fileHandler.Match)
+			r.Get("/stat", fileHandler.Stat)
+			r.Get("/hash", fileHandler.Hash)
+		})
 
-	spaceHandler := handlers.SpaceHandler{}
-	r.Route("/api/spaces", func(r chi.Router) {
-		r.Get("/all", spaceHandler.GetAll)
+		infoHandler := handlers.InfoHandler{Limits: limits, Signer: authOptions.Signer}
+		r.Get("/api/info", infoHandler.Get)
+
+		spaceHandler := handlers.SpaceHandler{}
+		r.Route("/api/spaces", func(r chi.Router) {
+			r.Get("/all", spaceHandler.GetAll)
+		})
 	})
 
 	return r
 }
 
-func Serve(r http.Handler, addr, certFile, privKeyFile string) error {
-	server := http3.Server{
-		Handler:    r,
-		Addr:       addr,
-		QuicConfig: &quic.Config{},
+// AdminRouter serves /healthz, /readyz and /metrics, unless serverMetrics is nil, without authentication
+// for probes and scrapers which can not speak HTTP/3, see ServeAdmin
+func AdminRouter(spaces map[string]string, serverMetrics *metrics.Server) *chi.Mux {
+	r := chi.NewRouter()
+	healthHandler := handlers.NewHealthHandler(spaces, true)
+	r.Get("/healthz", healthHandler.Healthz)
+	r.Get("/readyz", healthHandler.Readyz)
+	if serverMetrics != nil {
+		r.Method(http.MethodGet, "/metrics", serverMetrics.Registry.Handler())
 	}
+	return r
+}
 
-	return server.ListenAndServeTLS(certFile, privKeyFile)
+// ServeAdmin serves the handler of AdminRouter over plain HTTP. addr should only be reachable by probes and scrapers.
+func ServeAdmin(addr string, handler http.Handler) error {
+	return http.ListenAndServe(addr, handler)
 }
@@ -0,0 +1,171 @@
+// Package audit records who did what to which files, one JSON line per operation
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	OpDownload  = "download"
+	OpUpload    = "upload"
+	OpOverwrite = "overwrite"
+	OpMatch     = "match"
+	OpList      = "list"
+	OpTree      = "tree"
+	OpStat      = "stat"
+	OpHash      = "hash"
+)
+
+// Event is one operation of a user, Path is space relative like 'music/song.mp3'
+type Event struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Credential string    `json:"credential,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Operation  string    `json:"operation"`
+	Path       string    `json:"path"`
+	Bytes      int64     `json:"bytes"`
+	// Result is 'ok', 'denied' for authorization errors or 'error'
+	Result     string `json:"result"`
+	Status     int    `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// Result describes an HTTP status for events
+func Result(status int) string {
+	switch {
+	case status < 400:
+		return "ok"
+	case status == 401 || status == 403 || status == 429:
+		return "denied"
+	}
+	return "error"
+}
+
+// Logger is where events go, implementations must be safe for concurrent use
+type Logger interface {
+	Log(event Event)
+}
+
+// FileLogger writes events as JSON lines to a file. When the file grows over MaxSize, it is
+// renamed to 'path.1', older ones to 'path.2' and so on, keeping MaxBackups of them.
+type FileLogger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewFileLogger(path string, maxSize int64, maxBackups int) (*FileLogger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, err
+	}
+
+	l := &FileLogger{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Log writes the event, errors are reported with the default slog logger since the operation already happened
+func (l *FileLogger) Log(event Event) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("audit: encoding event", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err = l.rotate(); err != nil {
+			slog.Error("audit: rotating", err)
+		}
+	}
+
+	// reopened after failed rotations, or after Close by a late request
+	if l.file == nil {
+		if err = l.open(); err != nil {
+			slog.Error("audit: opening", err)
+			return
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("audit: writing", err)
+	}
+}
+
+func (l *FileLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *FileLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file, l.size = file, stat.Size()
+	return nil
+}
+
+func (l *FileLogger) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if l.maxBackups <= 0 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return l.open()
+	}
+
+	os.Remove(l.backupPath(l.maxBackups))
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(l.backupPath(i), l.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.backupPath(1)); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *FileLogger) backupPath(num int) string {
+	return fmt.Sprintf("%s.%d", l.path, num)
+}
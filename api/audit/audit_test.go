@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLogger(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "logs/audit.jsonl")
+	event := Event{Time: time.Now(), User: "roger", RemoteAddr: "1.2.3.4:5678", Operation: OpDownload, Path: "music/money.mp3", Bytes: 42, Result: "ok", Status: 200}
+	line, err := json.Marshal(event)
+	if err != nil {
+		panic(err)
+	}
+
+	// room for two lines in each file
+	l, err := NewFileLogger(logPath, int64(2*len(line)+2), 2)
+	assert.Nil(t, err)
+	for i := 0; i < 7; i++ {
+		l.Log(event)
+	}
+	assert.Nil(t, l.Close())
+
+	assert.Equal(t, countEvents(t, logPath), 1)
+	assert.Equal(t, countEvents(t, logPath+".1"), 2)
+	assert.Equal(t, countEvents(t, logPath+".2"), 2)
+	assert.NoFileExists(t, logPath+".3")
+
+	// appends after reopening
+	l, err = NewFileLogger(logPath, int64(2*len(line)+2), 2)
+	assert.Nil(t, err)
+	l.Log(event)
+	assert.Nil(t, l.Close())
+	assert.Equal(t, countEvents(t, logPath), 2)
+
+	file, err := os.Open(logPath)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	var decoded Event
+	assert.Nil(t, json.NewDecoder(file).Decode(&decoded))
+	assert.Equal(t, decoded.User, "roger")
+	assert.Equal(t, decoded.Path, "music/money.mp3")
+	assert.Equal(t, decoded.Bytes, int64(42))
+}
+
+func TestResult(t *testing.T) {
+	assert.Equal(t, Result(200), "ok")
+	assert.Equal(t, Result(403), "denied")
+	assert.Equal(t, Result(404), "error")
+	assert.Equal(t, Result(500), "error")
+}
+
+func countEvents(t *testing.T, filePath string) int {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &event))
+		count++
+	}
+	return count
+}
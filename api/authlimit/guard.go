@@ -0,0 +1,153 @@
+package authlimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Options of a Guard, durations should be positive
+type Options struct {
+	// MaxAddressFailures locks out an address, it should be high enough for users behind the same NAT
+	MaxAddressFailures int
+	// MaxCredentialFailures locks out a credential, like a GUID or a token, no matter where it comes from
+	MaxCredentialFailures int
+	// Window is how long failures are remembered
+	Window time.Duration
+	// Lockout is the duration of the first lockout, each next one is twice as long up to MaxLockout
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// MaxDelay caps the delay of failed responses, which doubles with each failure. Zero disables delays.
+	MaxDelay time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxAddressFailures:    30,
+		MaxCredentialFailures: 5,
+		Window:                10 * time.Minute,
+		Lockout:               time.Minute,
+		MaxLockout:            time.Hour,
+		MaxDelay:              2 * time.Second,
+	}
+}
+
+// firstDelay is the delay of the first failed response, doubled for each next failure
+const firstDelay = 100 * time.Millisecond
+
+// Guard limits authentication failures separately per address and per credential.
+// Failures are logged with the message 'auth failure' and the address and reason attributes, for tools like fail2ban.
+// A nil Guard allows everything.
+type Guard struct {
+	addresses   *Limiter
+	credentials *Limiter
+	maxDelay    time.Duration
+}
+
+func NewGuard(opts Options) *Guard {
+	return &Guard{
+		addresses:   NewLimiter(opts.MaxAddressFailures, opts.Window, opts.Lockout, opts.MaxLockout),
+		credentials: NewLimiter(opts.MaxCredentialFailures, opts.Window, opts.Lockout, opts.MaxLockout),
+		maxDelay:    opts.MaxDelay,
+	}
+}
+
+// CredentialLocked returns how long the credential is still locked out for, zero if it is not or if it is empty,
+// like for requests without any
+func (g *Guard) CredentialLocked(credential string) time.Duration {
+	if g == nil || credential == "" {
+		return 0
+	}
+	return g.credentials.Locked(credentialKey(credential))
+}
+
+// AddressLocked returns how long the address of the request is still locked out for, zero if it is not.
+// Every attempt from a locked address is refused, MaxAddressFailures is what keeps this fair to users behind a NAT.
+func (g *Guard) AddressLocked(r *http.Request) time.Duration {
+	if g == nil {
+		return 0
+	}
+	return g.addresses.Locked(addressKey(r))
+}
+
+// Fail records a failed authentication, logs it and delays the response of the request
+func (g *Guard) Fail(r *http.Request, credential, reason string) {
+	if g == nil {
+		return
+	}
+
+	host := remoteHost(r)
+	logger := slog.FromContext(r.Context())
+	logger.Warn("auth failure", "address", host, "reason", reason)
+
+	failures, lockout := g.addresses.Fail(addressKey(r))
+	if lockout > 0 {
+		logger.Warn("auth lockout of address", "address", host, "lockout", lockout, "failures", failures)
+	}
+	if credential != "" {
+		credentialFailures, lockout := g.credentials.Fail(credentialKey(credential))
+		if lockout > 0 {
+			logger.Warn("auth lockout of credential", "address", host, "lockout", lockout, "failures", credentialFailures)
+		}
+		if credentialFailures > failures {
+			failures = credentialFailures
+		}
+	}
+
+	g.delay(r, failures)
+}
+
+// Succeed forgets the failures of the credential. Failures of the address are kept,
+// since others behind the same address may still be guessing.
+func (g *Guard) Succeed(credential string) {
+	if g == nil || credential == "" {
+		return
+	}
+	g.credentials.Reset(credentialKey(credential))
+}
+
+func (g *Guard) delay(r *http.Request, failures int) {
+	if g.maxDelay <= 0 || failures <= 0 {
+		return
+	}
+
+	delay := g.maxDelay
+	if failures < 32 && firstDelay<<(failures-1) < g.maxDelay {
+		delay = firstDelay << (failures - 1)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// addressKey is the IP of the request, or its /64 network for IPv6 since hosts usually get a whole /64
+func addressKey(r *http.Request) string {
+	host := remoteHost(r)
+	ip := net.ParseIP(host)
+	if ip == nil || ip.To4() != nil {
+		return host
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+// credentialKey hashes the credential, so secrets are not kept in memory for longer than needed
+func credentialKey(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,112 @@
+// Package authlimit slows down and locks out clients which fail to authenticate too often
+package authlimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter counts failures by key and locks a key out after too many in a window.
+// Each lockout of the same key lasts twice as long as the one before it.
+type Limiter struct {
+	maxFailures int
+	window      time.Duration
+	lockout     time.Duration
+	maxLockout  time.Duration
+	now         func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockouts    int
+	lockedUntil time.Time
+}
+
+func NewLimiter(maxFailures int, window, lockout, maxLockout time.Duration) *Limiter {
+	return &Limiter{
+		maxFailures: maxFailures,
+		window:      window,
+		lockout:     lockout,
+		maxLockout:  maxLockout,
+		now:         time.Now,
+		entries:     map[string]*entry{},
+	}
+}
+
+// Locked returns how long key is still locked out for, zero if it is not
+func (l *Limiter) Locked(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	if left := e.lockedUntil.Sub(l.now()); left > 0 {
+		return left
+	}
+	return 0
+}
+
+// Fail records a failure of key and returns the number of failures in the current window,
+// and the lockout duration if this failure locked key out
+func (l *Limiter) Fail(key string) (int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	if now.Sub(e.lastFailure) > l.window {
+		e.failures = 0
+	}
+	e.failures++
+	e.lastFailure = now
+
+	failures := e.failures
+	if e.failures < l.maxFailures {
+		return failures, 0
+	}
+
+	lockout := l.lockout << e.lockouts
+	if lockout > l.maxLockout || lockout <= 0 {
+		lockout = l.maxLockout
+	}
+	e.failures = 0
+	e.lockouts++
+	e.lockedUntil = now.Add(lockout)
+	return failures, lockout
+}
+
+// Reset forgets the failures of key
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.entries, key)
+}
+
+// sweep forgets keys with no failures and no lockout for a window, so lockouts stop growing
+// and memory is given back. It runs at most once a window.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = now
+
+	for key, e := range l.entries {
+		if now.Sub(e.lastFailure) > l.window && now.Sub(e.lockedUntil) > l.window {
+			delete(l.entries, key)
+		}
+	}
+}
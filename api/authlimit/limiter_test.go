@@ -0,0 +1,100 @@
+package authlimit
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(3, 10*time.Minute, time.Minute, 3*time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i := 1; i < 3; i++ {
+		failures, lockout := l.Fail("1.2.3.4")
+		assert.Equal(t, failures, i)
+		assert.Equal(t, lockout, time.Duration(0))
+	}
+	assert.Equal(t, l.Locked("1.2.3.4"), time.Duration(0))
+
+	_, lockout := l.Fail("1.2.3.4")
+	assert.Equal(t, lockout, time.Minute)
+	assert.Equal(t, l.Locked("1.2.3.4"), time.Minute)
+	assert.Equal(t, l.Locked("5.6.7.8"), time.Duration(0))
+
+	// lockouts expire, and the next ones are longer up to the max
+	now = now.Add(time.Minute)
+	assert.Equal(t, l.Locked("1.2.3.4"), time.Duration(0))
+	for _, expected := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		l.Fail("1.2.3.4")
+		l.Fail("1.2.3.4")
+		_, lockout = l.Fail("1.2.3.4")
+		assert.Equal(t, lockout, expected)
+		now = now.Add(lockout)
+	}
+
+	// failures older than the window are forgotten
+	l.Fail("5.6.7.8")
+	l.Fail("5.6.7.8")
+	now = now.Add(11 * time.Minute)
+	failures, _ := l.Fail("5.6.7.8")
+	assert.Equal(t, failures, 1)
+
+	// so are lockouts, and the keys with them
+	now = now.Add(30 * time.Minute)
+	l.Fail("9.9.9.9")
+	assert.NotContains(t, l.entries, "1.2.3.4")
+
+	l.Reset("9.9.9.9")
+	assert.NotContains(t, l.entries, "9.9.9.9")
+}
+
+func TestGuard(t *testing.T) {
+	opts := DefaultOptions()
+	opts.MaxAddressFailures = 4
+	opts.MaxCredentialFailures = 2
+	opts.MaxDelay = 0
+	g := NewGuard(opts)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "1.2.3.4:5678"
+	other := httptest.NewRequest("GET", "/", nil)
+	other.RemoteAddr = "1.2.3.4:9999"
+
+	g.Fail(r, "wrong-guid", "unknown GUID")
+	g.Succeed("wrong-guid")
+	g.Fail(r, "wrong-guid", "unknown GUID")
+	assert.Equal(t, g.CredentialLocked("wrong-guid"), time.Duration(0))
+
+	// the credential is locked out from anywhere, other credentials from the same address are not yet
+	g.Fail(r, "wrong-guid", "unknown GUID")
+	assert.Greater(t, g.CredentialLocked("wrong-guid"), time.Duration(0))
+	assert.Equal(t, g.CredentialLocked("right-guid"), time.Duration(0))
+	assert.Equal(t, g.AddressLocked(other), time.Duration(0))
+
+	// the address is locked out, the credentials which did not fail are not
+	g.Fail(r, "another-guid", "unknown GUID")
+	assert.Greater(t, g.AddressLocked(other), time.Duration(0))
+	assert.Equal(t, g.CredentialLocked("right-guid"), time.Duration(0))
+
+	// IPv6 addresses are limited by their /64 network
+	v6 := httptest.NewRequest("GET", "/", nil)
+	v6.RemoteAddr = "[2001:db8::1]:5678"
+	v6Same := httptest.NewRequest("GET", "/", nil)
+	v6Same.RemoteAddr = "[2001:db8::2]:5678"
+	v6Other := httptest.NewRequest("GET", "/", nil)
+	v6Other.RemoteAddr = "[2001:db8:0:1::1]:5678"
+	for i := 0; i < 4; i++ {
+		g.Fail(v6, "", "no credentials")
+	}
+	assert.Greater(t, g.AddressLocked(v6Same), time.Duration(0))
+	assert.Equal(t, g.AddressLocked(v6Other), time.Duration(0))
+
+	var nilGuard *Guard
+	nilGuard.Fail(r, "wrong-guid", "unknown GUID")
+	assert.Equal(t, nilGuard.CredentialLocked("wrong-guid"), time.Duration(0))
+	assert.Equal(t, nilGuard.AddressLocked(r), time.Duration(0))
+}
@@ -0,0 +1,110 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Reloader serves a certificate through tls.Config.GetCertificate and re-reads it when the files change,
+// so certificates can be rotated without restarting the server
+type Reloader struct {
+	certPath string
+	keyPath  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// stamps of the files at the last reload attempt, so a broken file is not retried until it changes again
+	certStamp fileStamp
+	keyStamp  fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewReloader loads the certificate, which unlike later reloads must succeed
+func NewReloader(certPath, keyPath string) (*Reloader, error) {
+	r := &Reloader{certPath: certPath, keyPath: keyPath}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload re-reads the certificate and key. If they fail to load, the current certificate is kept.
+func (r *Reloader) Reload() error {
+	certStamp, keyStamp := stampOf(r.certPath), stampOf(r.keyPath)
+
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certStamp, r.keyStamp = certStamp, keyStamp
+	if err != nil {
+		return err
+	}
+
+	if r.cert != nil {
+		slog.Info("certificate reloaded", "old", fingerprint(r.cert), "new", fingerprint(&cert))
+	}
+	r.cert = &cert
+	return nil
+}
+
+// Watch reloads the certificate whenever the files change, checking them every interval until stop is closed.
+// A nil stop watches forever.
+func (r *Reloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := r.reloadIfChanged(); err != nil {
+				slog.Error("reloading certificate failed, keeping the current one", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (r *Reloader) reloadIfChanged() (bool, error) {
+	r.mu.RLock()
+	changed := stampOf(r.certPath) != r.certStamp || stampOf(r.keyPath) != r.keyStamp
+	r.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+	return true, r.Reload()
+}
+
+func stampOf(filePath string) fileStamp {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: stat.ModTime(), size: stat.Size()}
+}
+
+func fingerprint(cert *tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,76 @@
+package certs
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api/handlers/handlerstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloader(t *testing.T) {
+	base := t.TempDir()
+	certPath, keyPath := path.Join(base, "cert.pem"), path.Join(base, "key.pem")
+
+	writeTestKeyPair(certPath, keyPath, "time", time.Now().Add(-time.Hour))
+	r, err := NewReloader(certPath, keyPath)
+	assert.Nil(t, err)
+	first, _ := r.GetCertificate(nil)
+
+	changed, err := r.reloadIfChanged()
+	assert.False(t, changed)
+	assert.Nil(t, err)
+
+	// a new certificate is picked up when the files change
+	writeTestKeyPair(certPath, keyPath, "money", time.Now())
+	changed, err = r.reloadIfChanged()
+	assert.True(t, changed)
+	assert.Nil(t, err)
+	second, _ := r.GetCertificate(nil)
+	assert.NotEqual(t, first.Certificate[0], second.Certificate[0])
+
+	// a broken certificate is not served, and not retried until it changes again
+	if err := os.WriteFile(certPath, []byte("breathe in the air"), 0644); err != nil {
+		panic(err)
+	}
+	changed, err = r.reloadIfChanged()
+	assert.True(t, changed)
+	assert.NotNil(t, err)
+	current, _ := r.GetCertificate(nil)
+	assert.Equal(t, current, second)
+
+	changed, _ = r.reloadIfChanged()
+	assert.False(t, changed)
+
+	_, err = NewReloader(certPath, keyPath)
+	assert.NotNil(t, err)
+}
+
+func writeTestKeyPair(certPath, keyPath, commonName string, modTime time.Time) {
+	cert, key, err := handlerstest.MakeCert(commonName)
+	if err != nil {
+		panic(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+
+	if err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644); err != nil {
+		panic(err)
+	}
+	if err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		panic(err)
+	}
+
+	// mtime resolution can be coarse, so changes are made visible explicitly
+	for _, filePath := range []string{certPath, keyPath} {
+		if err = os.Chtimes(filePath, modTime, modTime); err != nil {
+			panic(err)
+		}
+	}
+}
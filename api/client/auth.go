@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/token"
+	"google.golang.org/protobuf/proto"
+)
+
+// tokens are refreshed this long before they expire, so they don't expire in flight
+const tokenRefreshMargin = 30 * time.Second
+
+type sessionToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// Login exchanges the GUID or the client certificate for a session token and its expiry time
+func (gc *GoSynClient) Login(ctx context.Context, baseAPIURL, GUID string) (token string, expiresAt time.Time, err error) {
+	ctx, span := startSpan(ctx, "Login", baseAPIURL)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseAPIURL+"/api/auth/login", nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// without a GUID, the client certificate is used to login
+	if GUID != "" {
+		req.Header.Set("Authorization", "simple "+GUID)
+	}
+
+	res, err := gc.do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		resBody, err := io.ReadAll(res.Body)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+
+		var resData pb.AuthLoginResponse
+		if err = proto.Unmarshal(resBody, &resData); err != nil {
+			return "", time.Time{}, err
+		}
+
+		return resData.Token, time.Unix(resData.ExpiresAt, 0), nil
+	}
+	if res.StatusCode == http.StatusNotFound {
+		// the server is older than session logins, and its 404 body is not an API error
+		return "", time.Time{}, oldServerErr()
+	}
+
+	return "", time.Time{}, getErr(res)
+}
+
+// tokenLogin is a login in flight, callers needing a token for the same server and GUID wait for it
+type tokenLogin struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// token returns a cached session token for the server and GUID, logging in if there is no valid one or the cached
+// one is the rejected token. The lock is not held while logging in, callers for the same key share one login.
+func (gc *GoSynClient) token(ctx context.Context, baseAPIURL, GUID, rejected string) (string, error) {
+	key := baseAPIURL + " " + GUID
+
+	gc.tokensMu.Lock()
+	if cached, ok := gc.tokens[key]; ok && cached.token != rejected && time.Until(cached.expiresAt) > tokenRefreshMargin {
+		gc.tokensMu.Unlock()
+		return cached.token, nil
+	}
+	if login, ok := gc.logins[key]; ok {
+		gc.tokensMu.Unlock()
+		select {
+		case <-login.done:
+			return login.token, login.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	login := &tokenLogin{done: make(chan struct{})}
+	if gc.logins == nil {
+		gc.logins = map[string]*tokenLogin{}
+	}
+	gc.logins[key] = login
+	gc.tokensMu.Unlock()
+
+	token, expiresAt, err := gc.Login(ctx, baseAPIURL, GUID)
+
+	gc.tokensMu.Lock()
+	delete(gc.logins, key)
+	if err == nil {
+		if gc.tokens == nil {
+			gc.tokens = map[string]sessionToken{}
+		}
+		gc.tokens[key] = sessionToken{token: token, expiresAt: expiresAt}
+	}
+	gc.tokensMu.Unlock()
+
+	login.token, login.err = token, err
+	close(login.done)
+	return token, err
+}
+
+// authDo sends the request with a session token. Requests without a body are retried with a fresh token if
+// the server rejects the token itself, for example when it restarted with a new secret. Other 401 responses,
+// like a space the user can not access, are returned as they are.
+func (gc *GoSynClient) authDo(req *http.Request, baseAPIURL, GUID string) (*http.Response, error) {
+	token, err := gc.token(req.Context(), baseAPIURL, GUID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	retryable := req.Body == nil
+	req.Header.Set("Authorization", "bearer "+token)
+	res, err := gc.do(req)
+	if err != nil || !tokenRejected(res) || !retryable {
+		return res, err
+	}
+	res.Body.Close()
+
+	if token, err = gc.token(req.Context(), baseAPIURL, GUID, token); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "bearer "+token)
+	return gc.do(req)
+}
+
+// tokenRejected tells if the server refused the session token, not the access of the user
+func tokenRejected(res *http.Response) bool {
+	return res.StatusCode == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") == token.RejectedChallenge
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/token"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestAuthDo(t *testing.T) {
+	GUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	logins := 0
+	validToken := ""
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "simple "+GUID {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		logins++
+		validToken = fmt.Sprintf("token-%d", logins)
+		resBytes, _ := proto.Marshal(&pb.AuthLoginResponse{Token: validToken, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		w.Write(resBytes)
+	})
+	mux.HandleFunc("/api/spaces/all", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "bearer "+validToken {
+			w.Header().Set("WWW-Authenticate", token.RejectedChallenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resBytes, _ := proto.Marshal(&pb.SpaceGetAllResponse{Spaces: []string{"music"}, Levels: map[string]string{"music": "read"}})
+		w.Write(resBytes)
+	})
+	mux.HandleFunc("/api/dirs/list", func(w http.ResponseWriter, r *http.Request) {
+		resBytes, _ := proto.Marshal(&pb.ApiError{Message: "unauthorized to access space"})
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write(resBytes)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	gc := &GoSynClient{C: server.Client()}
+
+	spaces, err := gc.GetAllSpaces(context.Background(), server.URL, GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, spaces, map[string]string{"music": "read"})
+
+	// cached token is used
+	_, err = gc.GetAllSpaces(context.Background(), server.URL, GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, logins, 1)
+
+	// server forgets the token, client should login again transparently
+	validToken = "something-else"
+	_, err = gc.GetAllSpaces(context.Background(), server.URL, GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, logins, 2)
+
+	// refusing the access of the user is not fixed by a new token
+	_, err = gc.GetDirList(context.Background(), server.URL, "marvel", GUID)
+	var apiErr *APIError
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, apiErr.StatusCode, http.StatusUnauthorized)
+	assert.Equal(t, logins, 2)
+}
+
+func TestTokenConcurrent(t *testing.T) {
+	slowGUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	fastGUID := "0b6ab9b1-6dc5-4c4d-9a55-6a3b4b4c5d2e"
+	release := make(chan struct{})
+	var mu sync.Mutex
+	logins := map[string]int{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		GUID := r.Header.Get("Authorization")[len("simple "):]
+		mu.Lock()
+		logins[GUID]++
+		mu.Unlock()
+		if GUID == slowGUID {
+			<-release
+		}
+		resBytes, _ := proto.Marshal(&pb.AuthLoginResponse{Token: "token-" + GUID, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		w.Write(resBytes)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	gc := &GoSynClient{C: server.Client()}
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 5)
+	errs := make([]error, 5)
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = gc.token(context.Background(), server.URL, slowGUID, "")
+		}(i)
+	}
+
+	for {
+		mu.Lock()
+		started := logins[slowGUID] != 0
+		mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a login for another GUID is not held up by the one in flight
+	token, err := gc.token(context.Background(), server.URL, fastGUID, "")
+	assert.Nil(t, err)
+	assert.Equal(t, token, "token-"+fastGUID)
+
+	// waiters give up with their context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = gc.token(ctx, server.URL, slowGUID, "")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	wg.Wait()
+	for i := range tokens {
+		assert.Nil(t, errs[i])
+		assert.Equal(t, tokens[i], "token-"+slowGUID)
+	}
+	assert.Equal(t, logins[slowGUID], 1)
+
+	// only the rejected token is replaced
+	token, err = gc.token(context.Background(), server.URL, slowGUID, "token-old")
+	assert.Nil(t, err)
+	assert.Equal(t, token, "token-"+slowGUID)
+	assert.Equal(t, logins[slowGUID], 1)
+	_, err = gc.token(context.Background(), server.URL, slowGUID, "token-"+slowGUID)
+	assert.Nil(t, err)
+	assert.Equal(t, logins[slowGUID], 2)
+}
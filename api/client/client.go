@@ -1,27 +1,53 @@
 package client
 
 import (
-	"errors"
+	"context"
 	"io"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/aigic8/gosyn/api/conflict"
 	"github.com/aigic8/gosyn/api/pb"
 	"google.golang.org/protobuf/proto"
 )
 
 type GoSynClient struct {
 	C *http.Client
+	// HeaderTimeout is the max time to wait for response headers after the request is sent, zero means no limit
+	HeaderTimeout time.Duration
+	// StallTimeout is the max time without any bytes of the request or response body moving, zero means no limit
+	StallTimeout time.Duration
+
+	tokensMu sync.Mutex
+	tokens   map[string]sessionToken
+	logins   map[string]*tokenLogin
+
+	infosMu sync.Mutex
+	infos   map[string]*pb.InfoGetResponse
+}
+
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
 }
 
 // TODO add test to clients
-func (gc *GoSynClient) GetDirList(baseAPIURL, dirPath, GUID string) ([]*pb.DirChild, error) {
-	req, err := http.NewRequest(http.MethodGet, baseAPIURL+"/api/dirs/list?path="+dirPath, nil)
+func (gc *GoSynClient) GetDirList(ctx context.Context, baseAPIURL, dirPath, GUID string) (children []*pb.DirChild, err error) {
+	ctx, span := startSpan(ctx, "GetDirList", baseAPIURL)
+	span.SetAttr("path", dirPath)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseAPIURL+"/api/dirs/list?path="+dirPath, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "simple "+GUID)
 
-	res, err := gc.C.Do(req)
+	res, err := gc.authDo(req, baseAPIURL, GUID)
 	if err != nil {
 		return nil, err
 	}
@@ -44,14 +70,17 @@ func (gc *GoSynClient) GetDirList(baseAPIURL, dirPath, GUID string) ([]*pb.DirCh
 	return nil, getErr(res)
 }
 
-func (gc *GoSynClient) GetDirTree(baseAPIURL, dirPath, GUID string) (map[string]*pb.TreeItem, error) {
-	req, err := http.NewRequest(http.MethodGet, baseAPIURL+"/api/dirs/tree?path="+dirPath, nil)
+func (gc *GoSynClient) GetDirTree(ctx context.Context, baseAPIURL, dirPath, GUID string) (tree map[string]*pb.TreeItem, err error) {
+	ctx, span := startSpan(ctx, "GetDirTree", baseAPIURL)
+	span.SetAttr("path", dirPath)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseAPIURL+"/api/dirs/tree?path="+dirPath, nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "simple "+GUID)
 
-	res, err := gc.C.Do(req)
+	res, err := gc.authDo(req, baseAPIURL, GUID)
 	if err != nil {
 		return nil, err
 	}
@@ -74,62 +103,100 @@ func (gc *GoSynClient) GetDirTree(baseAPIURL, dirPath, GUID string) (map[string]
 	return nil, getErr(res)
 }
 
-func (gc *GoSynClient) GetFile(baseAPIURL, filePath, GUID string) (io.ReadCloser, int64, error) {
-	req, err := http.NewRequest(http.MethodGet, baseAPIURL+"/api/files?path="+filePath, nil)
+// GetFile returns the body of the file and its size. The span of the call lasts until the body is closed.
+func (gc *GoSynClient) GetFile(ctx context.Context, baseAPIURL, filePath, GUID string) (body io.ReadCloser, size int64, err error) {
+	ctx, span := startSpan(ctx, "GetFile", baseAPIURL)
+	span.SetAttr("path", filePath)
+	defer func() {
+		if err != nil {
+			endSpan(span, err)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseAPIURL+"/api/files?path="+filePath, nil)
 	if err != nil {
 		return nil, 0, err
 	}
-	req.Header.Set("Authorization", "simple "+GUID)
 
-	res, err := gc.C.Do(req)
+	res, err := gc.authDo(req, baseAPIURL, GUID)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	if res.StatusCode != http.StatusOK {
+		defer res.Body.Close()
 		return nil, 0, getErr(res)
 	}
 
-	return res.Body, res.ContentLength, nil
+	span.SetAttr("size", res.ContentLength)
+	return &spanBody{ReadCloser: res.Body, span: span}, res.ContentLength, nil
 }
 
-func (gc *GoSynClient) PutNewFile(baseAPIURL, filePath, GUID, srcName string, isForced bool, reader io.Reader) error {
-	req, err := http.NewRequest(http.MethodPut, baseAPIURL+"/api/files/new", reader)
+type PutNewOptions struct {
+	Force            bool
+	Backup           conflict.BackupMode
+	RenameOnConflict bool
+}
+
+// PutNewFile uploads a file and returns the space path it was stored in
+func (gc *GoSynClient) PutNewFile(ctx context.Context, baseAPIURL, filePath, GUID, srcName string, opts PutNewOptions, reader io.Reader) (storedPath string, err error) {
+	ctx, span := startSpan(ctx, "PutNewFile", baseAPIURL)
+	span.SetAttr("path", filePath)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, baseAPIURL+"/api/files/new", reader)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	req.Header.Set("x-file-path", filePath)
-	if isForced {
+	if opts.Force {
 		req.Header.Set("x-force", "true")
 	} else {
 		req.Header.Set("x-force", "false")
 	}
+	if opts.Backup != conflict.BackupNone {
+		req.Header.Set("x-backup", string(opts.Backup))
+	}
+	if opts.RenameOnConflict {
+		req.Header.Set("x-rename-on-conflict", "true")
+	}
 	req.Header.Set("x-src-name", srcName)
-	req.Header.Set("Authorization", "simple "+GUID)
 
-	res, err := gc.C.Do(req)
+	res, err := gc.authDo(req, baseAPIURL, GUID)
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return getErr(res)
+		return "", getErr(res)
 	}
 
-	return nil
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var resData pb.FilePutNewResponse
+	if err = proto.Unmarshal(resBody, &resData); err != nil {
+		return "", err
+	}
+
+	return resData.Path, nil
 }
 
-func (gc *GoSynClient) GetMatches(baseAPIURL, GUID, pattern string) ([]string, error) {
-	req, err := http.NewRequest(http.MethodGet, baseAPIURL+"/api/files/matches?pattern="+pattern, nil)
+func (gc *GoSynClient) GetMatches(ctx context.Context, baseAPIURL, GUID, pattern string) (matches []string, err error) {
+	ctx, span := startSpan(ctx, "GetMatches", baseAPIURL)
+	span.SetAttr("pattern", pattern)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseAPIURL+"/api/files/matches?pattern="+pattern, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "simple "+GUID)
-
-	res, err := gc.C.Do(req)
+	res, err := gc.authDo(req, baseAPIURL, GUID)
 	if err != nil {
 		return nil, err
 	}
@@ -152,15 +219,17 @@ func (gc *GoSynClient) GetMatches(baseAPIURL, GUID, pattern string) ([]string, e
 	return nil, getErr(res)
 }
 
-func (gc *GoSynClient) GetAllSpaces(baseAPIURL, GUID string) ([]string, error) {
-	req, err := http.NewRequest(http.MethodGet, baseAPIURL+"/api/spaces/all", nil)
+// GetAllSpaces returns the access level of the user, like 'read' or 'write', by space name
+func (gc *GoSynClient) GetAllSpaces(ctx context.Context, baseAPIURL, GUID string) (levels map[string]string, err error) {
+	ctx, span := startSpan(ctx, "GetAllSpaces", baseAPIURL)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseAPIURL+"/api/spaces/all", nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "simple "+GUID)
-
-	res, err := gc.C.Do(req)
+	res, err := gc.authDo(req, baseAPIURL, GUID)
 	if err != nil {
 		return nil, err
 	}
@@ -177,21 +246,32 @@ func (gc *GoSynClient) GetAllSpaces(baseAPIURL, GUID string) ([]string, error) {
 			return nil, err
 		}
 
-		return resData.Spaces, nil
+		// servers from before access levels only send space names, which meant full access
+		if len(resData.Levels) == 0 && len(resData.Spaces) != 0 {
+			levels := make(map[string]string, len(resData.Spaces))
+			for _, space := range resData.Spaces {
+				levels[space] = "write"
+			}
+			return levels, nil
+		}
+
+		return resData.Levels, nil
 	}
 
 	return nil, getErr(res)
 }
 
-func (gc *GoSynClient) GetStat(baseAPIURL, GUID, statPath string) (*pb.StatInfo, error) {
-	req, err := http.NewRequest(http.MethodGet, baseAPIURL+"/api/files/stat?path="+statPath, nil)
+func (gc *GoSynClient) GetStat(ctx context.Context, baseAPIURL, GUID, statPath string) (stat *pb.StatInfo, err error) {
+	ctx, span := startSpan(ctx, "GetStat", baseAPIURL)
+	span.SetAttr("path", statPath)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseAPIURL+"/api/files/stat?path="+statPath, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "simple "+GUID)
-
-	res, err := gc.C.Do(req)
+	res, err := gc.authDo(req, baseAPIURL, GUID)
 	if err != nil {
 		return nil, err
 	}
@@ -214,6 +294,39 @@ func (gc *GoSynClient) GetStat(baseAPIURL, GUID, statPath string) (*pb.StatInfo,
 	return nil, getErr(res)
 }
 
+func (gc *GoSynClient) GetHash(ctx context.Context, baseAPIURL, GUID, hashPath string) (hash string, err error) {
+	ctx, span := startSpan(ctx, "GetHash", baseAPIURL)
+	span.SetAttr("path", hashPath)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseAPIURL+"/api/files/hash?path="+hashPath, nil)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := gc.authDo(req, baseAPIURL, GUID)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		resBody, err := io.ReadAll(res.Body)
+		if err != nil {
+			return "", err
+		}
+
+		var resData pb.FileGetHashResponse
+		if err = proto.Unmarshal(resBody, &resData); err != nil {
+			return "", err
+		}
+
+		return resData.Hash, nil
+	}
+
+	return "", getErr(res)
+}
+
 func getErr(res *http.Response) error {
 	resBody, err := io.ReadAll(res.Body)
 	if err != nil {
@@ -225,5 +338,5 @@ func getErr(res *http.Response) error {
 		return err
 	}
 
-	return errors.New(resData.Message)
+	return &APIError{StatusCode: res.StatusCode, Message: resData.Message}
 }
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aigic8/gosyn/api/logging"
+	"golang.org/x/exp/slog"
+)
+
+// LoggingTransport logs requests and responses at DebugLevel, and their headers at logging.TraceLevel.
+// Authorization headers are logged without credentials.
+type LoggingTransport struct {
+	Transport http.RoundTripper
+	Logger    *slog.Logger
+}
+
+func (t LoggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.Logger.Enabled(slog.DebugLevel) {
+		return t.Transport.RoundTrip(req)
+	}
+
+	attrs := []slog.Attr{slog.String("method", req.Method), slog.String("url", req.URL.String())}
+	if t.Logger.Enabled(logging.TraceLevel) {
+		t.Logger.LogAttrs(logging.TraceLevel, "http request", append(attrs, headersAttr(req.Header))...)
+	}
+
+	start := time.Now()
+	res, err := t.Transport.RoundTrip(req)
+	attrs = append(attrs, slog.Int64("duration_ms", time.Since(start).Milliseconds()))
+	if err != nil {
+		t.Logger.LogAttrs(slog.DebugLevel, "http request failed", append(attrs, slog.String("error", err.Error()))...)
+		return nil, err
+	}
+
+	attrs = append(attrs, slog.Int("status", res.StatusCode), slog.String("request_id", res.Header.Get(logging.RequestIDHeader)))
+	t.Logger.LogAttrs(slog.DebugLevel, "http response", attrs...)
+	if t.Logger.Enabled(logging.TraceLevel) {
+		t.Logger.LogAttrs(logging.TraceLevel, "http response headers", append(attrs, headersAttr(res.Header))...)
+	}
+	return res, nil
+}
+
+// headersAttr groups the headers by name, hiding credentials
+func headersAttr(header http.Header) slog.Attr {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]slog.Attr, 0, len(names))
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		if name == "Authorization" {
+			scheme, _, _ := strings.Cut(value, " ")
+			value = scheme + " <redacted>"
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.Group("headers", attrs...)
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aigic8/gosyn/api/logging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func TestLoggingTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(logging.RequestIDHeader, "3f2a9c1d")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer server.Close()
+
+	get := func(level slog.Level) string {
+		logs := &bytes.Buffer{}
+		handler, err := logging.NewHandler(logs, logging.Options{Level: level})
+		if err != nil {
+			panic(err)
+		}
+		c := &http.Client{Transport: LoggingTransport{Transport: http.DefaultTransport, Logger: slog.New(handler)}}
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/spaces/all", nil)
+		req.Header.Set("Authorization", "simple f3b1f1cb-d1e6-4700-8f96-c28182563729")
+		res, err := c.Do(req)
+		assert.Nil(t, err)
+		res.Body.Close()
+		return logs.String()
+	}
+
+	logs := get(slog.InfoLevel)
+	assert.Equal(t, logs, "")
+
+	logs = get(slog.DebugLevel)
+	assert.Contains(t, logs, "msg=\"http response\"")
+	assert.Contains(t, logs, "status=418")
+	assert.Contains(t, logs, "request_id=3f2a9c1d")
+	assert.NotContains(t, logs, "headers.")
+
+	logs = get(logging.TraceLevel)
+	assert.Contains(t, logs, "level=TRACE")
+	assert.Contains(t, logs, "headers.Authorization=\"simple <redacted>\"")
+	assert.NotContains(t, logs, "f3b1f1cb")
+}
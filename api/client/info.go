@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aigic8/gosyn/api/info"
+	"github.com/aigic8/gosyn/api/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+// GetInfo returns the version, protocol version, features and limits of the server. The answer is cached for
+// the server, since it does not change while the server runs. Servers from before /api/info, or before session
+// logins, get a ProtocolError.
+func (gc *GoSynClient) GetInfo(ctx context.Context, baseAPIURL, GUID string) (serverInfo *pb.InfoGetResponse, err error) {
+	gc.infosMu.Lock()
+	defer gc.infosMu.Unlock()
+	if cached, ok := gc.infos[baseAPIURL]; ok {
+		return cached, nil
+	}
+
+	ctx, span := startSpan(ctx, "GetInfo", baseAPIURL)
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseAPIURL+"/api/info", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := gc.authDo(req, baseAPIURL, GUID)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var resData pb.InfoGetResponse
+	switch res.StatusCode {
+	case http.StatusOK:
+		resBody, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		if err = proto.Unmarshal(resBody, &resData); err != nil {
+			return nil, err
+		}
+	case http.StatusNotFound:
+		return nil, oldServerErr()
+	default:
+		return nil, getErr(res)
+	}
+
+	if gc.infos == nil {
+		gc.infos = map[string]*pb.InfoGetResponse{}
+	}
+	gc.infos[baseAPIURL] = &resData
+	return &resData, nil
+}
+
+// ProtocolError is returned when the client or the server is too old for the other one
+type ProtocolError struct {
+	ClientVersion int32
+	ServerVersion int32
+	// ServerTooOld is false when the client is the one which is too old
+	ServerTooOld bool
+}
+
+func (e *ProtocolError) Error() string {
+	if e.ServerTooOld {
+		return fmt.Sprintf("server speaks protocol %d, this client needs at least %d, upgrade gsyn on the server", e.ServerVersion, info.MinProtocolVersion)
+	}
+	return fmt.Sprintf("server needs clients with protocol %d or newer, this client speaks %d, upgrade gsyn", e.ServerVersion, e.ClientVersion)
+}
+
+// oldServerErr is the error of servers which do not know /api/info or /api/auth/login, which speak protocol 0
+func oldServerErr() *ProtocolError {
+	return &ProtocolError{ClientVersion: info.ProtocolVersion, ServerVersion: 0, ServerTooOld: true}
+}
+
+// CheckProtocol returns a ProtocolError if the client and the server of serverInfo can not work together
+func CheckProtocol(serverInfo *pb.InfoGetResponse) error {
+	if serverInfo.ProtocolVersion < info.MinProtocolVersion {
+		return &ProtocolError{ClientVersion: info.ProtocolVersion, ServerVersion: serverInfo.ProtocolVersion, ServerTooOld: true}
+	}
+	if serverInfo.MinProtocolVersion > info.ProtocolVersion {
+		return &ProtocolError{ClientVersion: info.ProtocolVersion, ServerVersion: serverInfo.MinProtocolVersion}
+	}
+	return nil
+}
+
+// ErrUnsupported is wrapped by errors of features the server does not have
+var ErrUnsupported = errors.New("not supported by the server")
+
+// RequireFeatures returns an error wrapping ErrUnsupported, naming the first of features the server does not have
+func RequireFeatures(serverInfo *pb.InfoGetResponse, features ...string) error {
+	for _, feature := range features {
+		if !HasFeature(serverInfo, feature) {
+			return fmt.Errorf("'%s' is %w (version %s)", feature, ErrUnsupported, serverInfo.Version)
+		}
+	}
+	return nil
+}
+
+func HasFeature(serverInfo *pb.InfoGetResponse, feature string) bool {
+	for _, f := range serverInfo.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api/info"
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGetInfo(t *testing.T) {
+	GUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	infoRequests := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		resBytes, _ := proto.Marshal(&pb.AuthLoginResponse{Token: "token", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		w.Write(resBytes)
+	})
+	mux.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
+		infoRequests++
+		resBytes, _ := proto.Marshal(&pb.InfoGetResponse{
+			Version:         "v1.2.0",
+			ProtocolVersion: 1,
+			Features:        []string{info.FeatureHash},
+			Limits:          &pb.InfoLimits{MaxUploadSize: 1024},
+		})
+		w.Write(resBytes)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	gc := &GoSynClient{C: server.Client()}
+	serverInfo, err := gc.GetInfo(context.Background(), server.URL, GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, serverInfo.Version, "v1.2.0")
+	assert.Equal(t, serverInfo.GetLimits().GetMaxUploadSize(), int64(1024))
+	assert.Nil(t, CheckProtocol(serverInfo))
+	assert.Nil(t, RequireFeatures(serverInfo, info.FeatureHash))
+	assert.True(t, errors.Is(RequireFeatures(serverInfo, info.FeatureHash, info.FeatureBackup), ErrUnsupported))
+
+	// the info is cached
+	_, err = gc.GetInfo(context.Background(), server.URL, GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, infoRequests, 1)
+}
+
+func TestGetInfoOldServer(t *testing.T) {
+	withLogin := http.NewServeMux()
+	withLogin.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		resBytes, _ := proto.Marshal(&pb.AuthLoginResponse{Token: "token", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		w.Write(resBytes)
+	})
+	// servers from before session logins answer with a plain text 404
+	withoutLogin := http.NotFoundHandler()
+
+	for name, handler := range map[string]http.Handler{"withoutInfo": withLogin, "withoutLogin": withoutLogin} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(handler)
+			defer server.Close()
+
+			gc := &GoSynClient{C: server.Client()}
+			_, err := gc.GetInfo(context.Background(), server.URL, "f3b1f1cb-d1e6-4700-8f96-c28182563729")
+			var protocolErr *ProtocolError
+			assert.True(t, errors.As(err, &protocolErr))
+			assert.True(t, protocolErr.ServerTooOld)
+			assert.Equal(t, protocolErr.ServerVersion, int32(0))
+			assert.Contains(t, err.Error(), "upgrade gsyn on the server")
+		})
+	}
+}
+
+func TestCheckProtocol(t *testing.T) {
+	var protocolErr *ProtocolError
+	err := CheckProtocol(&pb.InfoGetResponse{ProtocolVersion: info.ProtocolVersion + 1, MinProtocolVersion: info.ProtocolVersion + 1})
+	assert.True(t, errors.As(err, &protocolErr))
+	assert.False(t, protocolErr.ServerTooOld)
+
+	err = CheckProtocol(&pb.InfoGetResponse{ProtocolVersion: info.MinProtocolVersion - 1})
+	assert.True(t, errors.As(err, &protocolErr))
+	assert.True(t, protocolErr.ServerTooOld)
+}
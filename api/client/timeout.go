@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aigic8/gosyn/api/tracing"
+)
+
+// TimeoutError is returned when a request is canceled because of HeaderTimeout or StallTimeout
+type TimeoutError struct {
+	Reason string
+}
+
+func (e *TimeoutError) Error() string   { return e.Reason }
+func (e *TimeoutError) Timeout() bool   { return true }
+func (e *TimeoutError) Temporary() bool { return true }
+
+// do sends the request, canceling it if response headers take longer than HeaderTimeout to arrive
+// after the request body is sent, or if no bytes of the request or response body move for StallTimeout.
+// The span of the request context is sent as the parent of the server spans.
+func (gc *GoSynClient) do(req *http.Request) (*http.Response, error) {
+	tracing.Inject(req.Context(), req.Header)
+	ctx, cancel := context.WithCancel(req.Context())
+	wd := &watchdog{cancel: cancel}
+
+	waitHeaders := func() {
+		wd.arm(gc.HeaderTimeout, fmt.Sprintf("no response headers received in %s", gc.HeaderTimeout))
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		wd.arm(gc.StallTimeout, fmt.Sprintf("upload stalled for %s", gc.StallTimeout))
+		req.Body = &watchedBody{body: req.Body, wd: wd, onEOF: waitHeaders}
+	} else {
+		waitHeaders()
+	}
+
+	res, err := gc.C.Do(req.WithContext(ctx))
+	if err != nil {
+		wd.stop()
+		cancel()
+		return nil, wd.wrap(err)
+	}
+
+	wd.arm(gc.StallTimeout, fmt.Sprintf("download stalled for %s", gc.StallTimeout))
+	res.Body = &watchedBody{body: res.Body, wd: wd, onEOF: wd.stop, onClose: func() {
+		wd.stop()
+		cancel()
+	}}
+	return res, nil
+}
+
+// watchdog cancels a request if it sees no activity for the timeout of the current phase
+type watchdog struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	cancel   context.CancelFunc
+	timeout  time.Duration
+	deadline time.Time
+	reason   string
+	err      error
+}
+
+// arm starts a new phase which fails with reason after timeout without activity. Zero timeout disables the phase.
+func (wd *watchdog) arm(timeout time.Duration, reason string) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	if wd.err != nil {
+		return
+	}
+
+	wd.timeout, wd.reason = timeout, reason
+	if timeout <= 0 {
+		if wd.timer != nil {
+			wd.timer.Stop()
+		}
+		return
+	}
+
+	wd.deadline = time.Now().Add(timeout)
+	if wd.timer == nil {
+		wd.timer = time.AfterFunc(timeout, wd.fire)
+	} else {
+		wd.timer.Reset(timeout)
+	}
+}
+
+// kick records activity, pushing the deadline of the current phase
+func (wd *watchdog) kick() {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if wd.timeout > 0 {
+		wd.deadline = time.Now().Add(wd.timeout)
+	}
+}
+
+func (wd *watchdog) stop() {
+	wd.arm(0, "")
+}
+
+func (wd *watchdog) fire() {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	if wd.timeout <= 0 || wd.err != nil {
+		return
+	}
+
+	// the timer is not reset on every kick, so it may fire before the actual deadline
+	if remaining := time.Until(wd.deadline); remaining > 0 {
+		wd.timer.Reset(remaining)
+		return
+	}
+
+	wd.err = &TimeoutError{Reason: wd.reason}
+	wd.cancel()
+}
+
+// wrap replaces the error caused by canceling the request with the reason of the timeout
+func (wd *watchdog) wrap(err error) error {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if wd.err != nil {
+		return wd.err
+	}
+	return err
+}
+
+type watchedBody struct {
+	body    io.ReadCloser
+	wd      *watchdog
+	onEOF   func()
+	onClose func()
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.wd.kick()
+	}
+
+	if err == io.EOF {
+		if b.onEOF != nil {
+			b.onEOF()
+			b.onEOF = nil
+		}
+	} else if err != nil {
+		err = b.wd.wrap(err)
+	}
+	return n, err
+}
+
+func (b *watchedBody) Close() error {
+	err := b.body.Close()
+	if b.onClose != nil {
+		b.onClose()
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeouts(t *testing.T) {
+	type testCase struct {
+		Name    string
+		Handler http.HandlerFunc
+		Timeout bool
+	}
+
+	testCases := []testCase{
+		{Name: "normal", Handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("time"))
+		}, Timeout: false},
+		{Name: "slowButMoving", Handler: func(w http.ResponseWriter, r *http.Request) {
+			for i := 0; i < 5; i++ {
+				w.Write([]byte("tick"))
+				w.(http.Flusher).Flush()
+				time.Sleep(50 * time.Millisecond)
+			}
+		}, Timeout: false},
+		{Name: "noHeaders", Handler: func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+		}, Timeout: true},
+		{Name: "stalled", Handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("tick"))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("tock"))
+		}, Timeout: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			server := httptest.NewServer(tc.Handler)
+			defer server.Close()
+
+			gc := &GoSynClient{C: server.Client(), HeaderTimeout: 100 * time.Millisecond, StallTimeout: 100 * time.Millisecond}
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			if err != nil {
+				panic(err)
+			}
+
+			res, err := gc.do(req)
+			if err == nil {
+				defer res.Body.Close()
+				_, err = io.ReadAll(res.Body)
+			}
+
+			var timeoutErr *TimeoutError
+			assert.Equal(t, errors.As(err, &timeoutErr), tc.Timeout)
+			if !tc.Timeout {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/aigic8/gosyn/api/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts the client span of a call, it records nothing if ctx has no span
+func startSpan(ctx context.Context, method, baseAPIURL string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "client."+method, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttr("server", baseAPIURL)
+	return ctx, span
+}
+
+func endSpan(span *tracing.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
+
+// spanBody ends the span of a download when the body is closed
+type spanBody struct {
+	io.ReadCloser
+	span *tracing.Span
+	err  error
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	endSpan(b.span, b.err)
+	return err
+}
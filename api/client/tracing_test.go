@@ -0,0 +1,63 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/tracing"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestClientTracing(t *testing.T) {
+	traceparents := map[string]string{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/auth/login", func(w http.ResponseWriter, r *http.Request) {
+		traceparents[r.URL.Path] = r.Header.Get(tracing.TraceparentHeader)
+		resBytes, _ := proto.Marshal(&pb.AuthLoginResponse{Token: "token", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+		w.Write(resBytes)
+	})
+	mux.HandleFunc("/api/files/stat", func(w http.ResponseWriter, r *http.Request) {
+		traceparents[r.URL.Path] = r.Header.Get(tracing.TraceparentHeader)
+		w.WriteHeader(http.StatusNotFound)
+		resBytes, _ := proto.Marshal(&pb.ApiError{Message: "path 'music/time.txt' does not exist"})
+		w.Write(resBytes)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	out := &bytes.Buffer{}
+	tracer := tracing.NewTracer("gsyn", tracing.NewWriterExporter(out))
+	ctx, root := tracer.Start(context.Background(), "cp")
+
+	gc := &GoSynClient{C: server.Client()}
+	_, err := gc.GetStat(ctx, server.URL, "f3b1f1cb-d1e6-4700-8f96-c28182563729", "music/time.txt")
+	assert.NotNil(t, err)
+	root.End()
+	assert.Nil(t, tracer.Close())
+
+	spans := map[string]tracing.JSONSpan{}
+	decoder := json.NewDecoder(out)
+	for decoder.More() {
+		var span tracing.JSONSpan
+		assert.Nil(t, decoder.Decode(&span))
+		spans[span.Name] = span
+	}
+
+	stat := spans["client.GetStat"]
+	assert.Equal(t, stat.ParentSpanID, root.SpanContext().SpanID().String())
+	assert.Equal(t, stat.Kind, "client")
+	assert.Equal(t, stat.Error, "path 'music/time.txt' does not exist")
+	assert.Equal(t, traceparents["/api/files/stat"], "00-"+stat.TraceID+"-"+stat.SpanID+"-01")
+
+	login := spans["client.Login"]
+	assert.Equal(t, login.ParentSpanID, stat.SpanID)
+	assert.Equal(t, traceparents["/api/auth/login"], "00-"+login.TraceID+"-"+login.SpanID+"-01")
+}
@@ -0,0 +1,155 @@
+// Package confine opens paths of a space without letting symlinks lead outside of it.
+// On Linux the kernel resolves paths with openat2(RESOLVE_BENEATH), elsewhere (and on
+// kernels older than 5.6) paths are resolved one component at a time in user space.
+package confine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy decides which symlinks are followed inside a space
+type SymlinkPolicy string
+
+const (
+	// SymlinksInside follows relative symlinks as long as they stay inside the space, it is the default
+	SymlinksInside SymlinkPolicy = "inside"
+	// SymlinksForbid does not follow any symlink
+	SymlinksForbid SymlinkPolicy = "forbid"
+)
+
+// maxSymlinks is the number of symlinks followed before giving up, same as Linux
+const maxSymlinks = 40
+
+var (
+	ErrEscapes      = errors.New("path leads outside of the space")
+	ErrSymlink      = errors.New("symlinks are not allowed in the space")
+	errTooManyLinks = errors.New("too many levels of symbolic links")
+)
+
+func ParseSymlinkPolicy(policy string) (SymlinkPolicy, error) {
+	switch SymlinkPolicy(policy) {
+	case "", SymlinksInside:
+		return SymlinksInside, nil
+	case SymlinksForbid:
+		return SymlinksForbid, nil
+	}
+	return "", fmt.Errorf("unknown symlink policy '%s'", policy)
+}
+
+// Refused reports whether err is because the path is not allowed by the symlink policy
+func Refused(err error) bool {
+	return errors.Is(err, ErrEscapes) || errors.Is(err, ErrSymlink)
+}
+
+// Open is like os.OpenFile for filePath, which must be lexically inside root.
+// Symlinks in filePath are followed according to policy and never outside of root.
+func Open(root, filePath string, flag int, perm os.FileMode, policy SymlinkPolicy) (*os.File, error) {
+	rel, err := relPath(root, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return open(root, rel, flag, perm, policy)
+}
+
+// Stat is like os.Stat for filePath, with symlinks followed like in Open
+func Stat(root, filePath string, policy SymlinkPolicy) (fs.FileInfo, error) {
+	rel, err := relPath(root, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return stat(root, rel, policy)
+}
+
+func relPath(root, filePath string) (string, error) {
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", &os.PathError{Op: "open", Path: filePath, Err: ErrEscapes}
+	}
+	return rel, nil
+}
+
+func openFallback(root, rel string, flag int, perm os.FileMode, policy SymlinkPolicy) (*os.File, error) {
+	resolved, err := resolve(root, rel, policy)
+	if err != nil {
+		return nil, err
+	}
+	// resolved has no symlinks, noFollow catches the last component being swapped for one in between
+	return os.OpenFile(resolved, flag|noFollow, perm)
+}
+
+func statFallback(root, rel string, policy SymlinkPolicy) (fs.FileInfo, error) {
+	resolved, err := resolve(root, rel, policy)
+	if err != nil {
+		return nil, err
+	}
+	return os.Lstat(resolved)
+}
+
+// resolve follows rel from root one component at a time and returns a path without symlinks under root.
+// Like RESOLVE_BENEATH, absolute symlinks and '..' above root are refused. Unlike it, a concurrent
+// rename of a directory in the path can still move the result outside of root before it is opened.
+func resolve(root, rel string, policy SymlinkPolicy) (string, error) {
+	fullPath := filepath.Join(root, rel)
+	pending := strings.Split(filepath.ToSlash(rel), "/")
+	resolved := []string{}
+	links := 0
+
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				return "", &os.PathError{Op: "open", Path: fullPath, Err: ErrEscapes}
+			}
+			resolved = resolved[:len(resolved)-1]
+			continue
+		}
+
+		current := filepath.Join(root, filepath.Join(resolved...), part)
+		stat, err := os.Lstat(current)
+		if err != nil {
+			// a missing last component can still be created, a missing directory can not
+			if errors.Is(err, os.ErrNotExist) && len(pending) == 0 {
+				return current, nil
+			}
+			return "", err
+		}
+
+		if stat.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, part)
+			continue
+		}
+
+		if policy == SymlinksForbid {
+			return "", &os.PathError{Op: "open", Path: fullPath, Err: ErrSymlink}
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", &os.PathError{Op: "open", Path: fullPath, Err: errTooManyLinks}
+		}
+
+		target, err := os.Readlink(current)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			return "", &os.PathError{Op: "open", Path: fullPath, Err: ErrEscapes}
+		}
+		pending = append(strings.Split(filepath.ToSlash(target), "/"), pending...)
+	}
+
+	return filepath.Join(root, filepath.Join(resolved...)), nil
+}
@@ -0,0 +1,128 @@
+//go:build linux
+
+package confine
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	noFollow  = unix.O_NOFOLLOW
+	directory = unix.O_DIRECTORY
+)
+
+func open(root, rel string, flag int, perm os.FileMode, policy SymlinkPolicy) (*os.File, error) {
+	rootFd, err := unix.Open(root, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	defer unix.Close(rootFd)
+
+	file, err := openBeneath(rootFd, root, rel, flag, perm, policy)
+	if errors.Is(err, unix.ENOSYS) {
+		// kernels before 5.6, or seccomp filters not knowing about openat2
+		return openFallback(root, rel, flag, perm, policy)
+	}
+	return file, err
+}
+
+// openBeneath opens rel relative to the directory dirFd at dirPath with openat2, which never resolves outside of it.
+// Without openat2 the error is unix.ENOSYS.
+func openBeneath(dirFd int, dirPath, rel string, flag int, perm os.FileMode, policy SymlinkPolicy) (*os.File, error) {
+	fullPath := filepath.Join(dirPath, rel)
+
+	how := unix.OpenHow{
+		Flags:   uint64(flag | unix.O_CLOEXEC),
+		Resolve: unix.RESOLVE_BENEATH | unix.RESOLVE_NO_MAGICLINKS,
+	}
+	// openat2 refuses a mode without O_CREAT
+	if flag&os.O_CREATE != 0 {
+		how.Mode = uint64(perm.Perm())
+	}
+	if policy == SymlinksForbid {
+		how.Resolve |= unix.RESOLVE_NO_SYMLINKS
+	}
+
+	var fd int
+	var err error
+	for {
+		fd, err = unix.Openat2(dirFd, rel, &how)
+		// EAGAIN means a rename raced with resolving '..', it is safe to retry
+		if err != unix.EAGAIN && err != unix.EINTR {
+			break
+		}
+	}
+
+	switch {
+	case errors.Is(err, unix.ENOSYS):
+		return nil, err
+	case errors.Is(err, unix.EXDEV):
+		return nil, &os.PathError{Op: "open", Path: fullPath, Err: ErrEscapes}
+	case errors.Is(err, unix.ELOOP) && policy == SymlinksForbid:
+		return nil, &os.PathError{Op: "open", Path: fullPath, Err: ErrSymlink}
+	case err != nil:
+		return nil, &os.PathError{Op: "open", Path: fullPath, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), fullPath), nil
+}
+
+func stat(root, rel string, policy SymlinkPolicy) (fs.FileInfo, error) {
+	file, err := open(root, rel, unix.O_PATH, 0, policy)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return file.Stat()
+}
+
+func (d *Dir) open(name string, flag int, perm os.FileMode) (*os.File, error) {
+	file, err := openBeneath(int(d.file.Fd()), d.Path(), name, flag, perm, d.policy)
+	if !errors.Is(err, unix.ENOSYS) {
+		return file, err
+	}
+
+	// a name has no directories in it to resolve, not following it as a symlink is all RESOLVE_BENEATH checks
+	fd, err := unix.Openat(int(d.file.Fd()), name, flag|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(perm.Perm()))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: d.Join(name), Err: err}
+	}
+	return os.NewFile(uintptr(fd), d.Join(name)), nil
+}
+
+func (d *Dir) stat(name string) (fs.FileInfo, error) {
+	file, err := d.open(name, unix.O_PATH, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return file.Stat()
+}
+
+func (d *Dir) rename(oldName, newName string) error {
+	if err := unix.Renameat(int(d.file.Fd()), oldName, int(d.file.Fd()), newName); err != nil {
+		return &os.LinkError{Op: "rename", Old: d.Join(oldName), New: d.Join(newName), Err: err}
+	}
+	return nil
+}
+
+func (d *Dir) remove(name string) error {
+	if err := unix.Unlinkat(int(d.file.Fd()), name, 0); err != nil {
+		return &os.PathError{Op: "remove", Path: d.Join(name), Err: err}
+	}
+	return nil
+}
+
+func (d *Dir) chmod(name string, mode os.FileMode) error {
+	if err := unix.Fchmodat(int(d.file.Fd()), name, uint32(mode.Perm()), 0); err != nil {
+		return &os.PathError{Op: "chmod", Path: d.Join(name), Err: err}
+	}
+	return nil
+}
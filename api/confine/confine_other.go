@@ -0,0 +1,45 @@
+//go:build !linux
+
+package confine
+
+import (
+	"io/fs"
+	"os"
+)
+
+const (
+	// noFollow is zero since O_NOFOLLOW is not available everywhere, leaving only the checks of resolve
+	noFollow  = 0
+	directory = 0
+)
+
+func open(root, rel string, flag int, perm os.FileMode, policy SymlinkPolicy) (*os.File, error) {
+	return openFallback(root, rel, flag, perm, policy)
+}
+
+func stat(root, rel string, policy SymlinkPolicy) (fs.FileInfo, error) {
+	return statFallback(root, rel, policy)
+}
+
+// the entries of a Dir are reached through its path, so like with resolve, a rename of a directory above it can
+// still move them outside of the space
+
+func (d *Dir) open(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return openFallback(d.Path(), name, flag, perm, d.policy)
+}
+
+func (d *Dir) stat(name string) (fs.FileInfo, error) {
+	return statFallback(d.Path(), name, d.policy)
+}
+
+func (d *Dir) rename(oldName, newName string) error {
+	return os.Rename(d.Join(oldName), d.Join(newName))
+}
+
+func (d *Dir) remove(name string) error {
+	return os.Remove(d.Join(name))
+}
+
+func (d *Dir) chmod(name string, mode os.FileMode) error {
+	return os.Chmod(d.Join(name), mode)
+}
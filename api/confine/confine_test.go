@@ -0,0 +1,156 @@
+package confine
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type confineTestCase struct {
+	Name   string
+	Path   string
+	Policy SymlinkPolicy
+	Data   string
+	Err    error
+}
+
+func TestConfine(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "space")
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0777); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dir/comfortably-numb.txt"), []byte("hello, is there anybody in there?"), 0666); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "outsider.txt"), []byte("I am an outsider."), 0666); err != nil {
+		panic(err)
+	}
+
+	links := map[string]string{
+		"inside.txt":       "dir/comfortably-numb.txt",
+		"dir/up.txt":       "../inside.txt",
+		"outside.txt":      "../outsider.txt",
+		"absolute.txt":     filepath.Join(root, "dir/comfortably-numb.txt"),
+		"outsideDir":       "..",
+		"loop.txt":         "loop.txt",
+		"dangling.txt":     "dir/new.txt",
+		"dir/escaping.txt": "../../outsider.txt",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			panic(err)
+		}
+	}
+
+	song := "hello, is there anybody in there?"
+	testCases := []confineTestCase{
+		{Name: "normal", Path: "dir/comfortably-numb.txt", Data: song},
+		{Name: "normalForbid", Path: "dir/comfortably-numb.txt", Policy: SymlinksForbid, Data: song},
+		{Name: "insideLink", Path: "inside.txt", Data: song},
+		{Name: "insideLinkForbid", Path: "inside.txt", Policy: SymlinksForbid, Err: ErrSymlink},
+		{Name: "chainedLinks", Path: "dir/up.txt", Data: song},
+		{Name: "outsideLink", Path: "outside.txt", Err: ErrEscapes},
+		{Name: "absoluteLink", Path: "absolute.txt", Err: ErrEscapes},
+		{Name: "outsideDirLink", Path: "outsideDir/outsider.txt", Err: ErrEscapes},
+		{Name: "escapingLink", Path: "dir/escaping.txt", Err: ErrEscapes},
+		{Name: "lexicalTraversal", Path: "../outsider.txt", Err: ErrEscapes},
+		{Name: "notExists", Path: "dir/wish-you-were-here.txt", Err: os.ErrNotExist},
+	}
+
+	for _, tc := range testCases {
+		for name, openFunc := range map[string]func(string, string, int, os.FileMode, SymlinkPolicy) (*os.File, error){"kernel": open, "fallback": openFallback} {
+			t.Run(tc.Name+"/"+name, func(t *testing.T) {
+				rel, err := relPath(root, filepath.Join(root, tc.Path))
+				if err == nil {
+					var file *os.File
+					file, err = openFunc(root, rel, os.O_RDONLY, 0, tc.Policy)
+					if err == nil {
+						defer file.Close()
+						data, readErr := io.ReadAll(file)
+						assert.Nil(t, readErr)
+						assert.Equal(t, string(data), tc.Data)
+					}
+				}
+
+				if tc.Err == nil {
+					assert.Nil(t, err)
+				} else {
+					assert.True(t, errors.Is(err, tc.Err), "expected %v, got %v", tc.Err, err)
+				}
+			})
+		}
+	}
+
+	_, err := Open(root, filepath.Join(root, "loop.txt"), os.O_RDONLY, 0, SymlinksInside)
+	assert.NotNil(t, err)
+	assert.False(t, Refused(err))
+
+	_, err = resolve(root, "loop.txt", SymlinksInside)
+	assert.NotNil(t, err)
+
+	// creating through a dangling link creates its target
+	file, err := Open(root, filepath.Join(root, "dangling.txt"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666, SymlinksInside)
+	assert.Nil(t, err)
+	file.Close()
+	assert.FileExists(t, filepath.Join(root, "dir/new.txt"))
+
+	stat, err := Stat(root, filepath.Join(root, "inside.txt"), SymlinksInside)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.Size(), int64(len(song)))
+
+	stat, err = Stat(root, filepath.Join(root, "dir"), SymlinksForbid)
+	assert.Nil(t, err)
+	assert.True(t, stat.IsDir())
+
+	_, err = Stat(root, filepath.Join(root, "outside.txt"), SymlinksInside)
+	assert.True(t, Refused(err))
+}
+
+func TestDir(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "space")
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0777); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "outsider.txt"), []byte("I am an outsider."), 0666); err != nil {
+		panic(err)
+	}
+	if err := os.Symlink("../../outsider.txt", filepath.Join(root, "dir/outside.txt")); err != nil {
+		panic(err)
+	}
+	if err := os.Symlink("..", filepath.Join(root, "outsideDir")); err != nil {
+		panic(err)
+	}
+
+	_, err := OpenDir(root, filepath.Join(root, "outsideDir"), SymlinksInside)
+	assert.ErrorIs(t, err, ErrEscapes)
+
+	dir, err := OpenDir(root, filepath.Join(root, "dir"), SymlinksInside)
+	if err != nil {
+		panic(err)
+	}
+	defer dir.Close()
+
+	file, err := dir.Open("comfortably-numb.txt", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	assert.Nil(t, err)
+	file.Close()
+	assert.Nil(t, dir.Rename("comfortably-numb.txt", "wish-you-were-here.txt"))
+
+	names, err := dir.Names()
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, names, []string{"outside.txt", "wish-you-were-here.txt"})
+
+	_, err = dir.Stat("outside.txt")
+	assert.ErrorIs(t, err, ErrEscapes)
+	_, err = dir.Open("../outsider.txt", os.O_RDONLY, 0)
+	assert.ErrorIs(t, err, ErrEscapes)
+	assert.ErrorIs(t, dir.Rename("wish-you-were-here.txt", "../../stolen.txt"), ErrEscapes)
+
+	assert.Nil(t, dir.Remove("wish-you-were-here.txt"))
+	assert.NoFileExists(t, filepath.Join(root, "dir/wish-you-were-here.txt"))
+}
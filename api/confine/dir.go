@@ -0,0 +1,110 @@
+package confine
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Dir is a directory of a space opened once, its entries are opened, renamed and removed relative to it.
+// On Linux this is done with its file descriptor, so a directory above it being renamed or replaced by a
+// symlink in between can not move them outside of the space.
+type Dir struct {
+	file   *os.File
+	policy SymlinkPolicy
+}
+
+// OpenDir opens the directory dirPath, which must be lexically inside root, like Open
+func OpenDir(root, dirPath string, policy SymlinkPolicy) (*Dir, error) {
+	file, err := Open(root, dirPath, os.O_RDONLY|directory, 0, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+	if err == nil && !stat.IsDir() {
+		err = &os.PathError{Op: "open", Path: dirPath, Err: syscall.ENOTDIR}
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Dir{file: file, policy: policy}, nil
+}
+
+func (d *Dir) Close() error {
+	return d.file.Close()
+}
+
+// Path is the path the directory was opened at
+func (d *Dir) Path() string {
+	return d.file.Name()
+}
+
+// Join returns the path of the entry name
+func (d *Dir) Join(name string) string {
+	return filepath.Join(d.Path(), name)
+}
+
+// Open is like os.OpenFile for the entry name, symlinks are followed like in Open
+func (d *Dir) Open(name string, flag int, perm os.FileMode) (*os.File, error) {
+	if err := d.checkName(name); err != nil {
+		return nil, err
+	}
+	return d.open(name, flag, perm)
+}
+
+// Stat is like os.Stat for the entry name, symlinks are followed like in Open
+func (d *Dir) Stat(name string) (fs.FileInfo, error) {
+	if err := d.checkName(name); err != nil {
+		return nil, err
+	}
+	return d.stat(name)
+}
+
+// Rename renames the entry oldName to newName, replacing newName if it exists
+func (d *Dir) Rename(oldName, newName string) error {
+	if err := d.checkName(oldName); err != nil {
+		return err
+	}
+	if err := d.checkName(newName); err != nil {
+		return err
+	}
+	return d.rename(oldName, newName)
+}
+
+// Remove removes the entry name, which must not be a directory
+func (d *Dir) Remove(name string) error {
+	if err := d.checkName(name); err != nil {
+		return err
+	}
+	return d.remove(name)
+}
+
+// Chmod changes the permissions of the entry name
+func (d *Dir) Chmod(name string, mode os.FileMode) error {
+	if err := d.checkName(name); err != nil {
+		return err
+	}
+	return d.chmod(name, mode)
+}
+
+// Names lists the names of the entries
+func (d *Dir) Names() ([]string, error) {
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return d.file.Readdirnames(-1)
+}
+
+// checkName refuses names which are not of an entry of the directory, but a path through others
+func (d *Dir) checkName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/"+string(os.PathSeparator)) {
+		return &os.PathError{Op: "open", Path: d.Join(name), Err: ErrEscapes}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package conflict
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aigic8/gosyn/api/confine"
+)
+
+type BackupMode string
+
+// PartialSuffix ends the names of files which are still being written, see PartialName
+const PartialSuffix = ".gsyn-part"
+
+const (
+	BackupNone     BackupMode = ""
+	BackupSimple   BackupMode = "simple"
+	BackupNumbered BackupMode = "numbered"
+)
+
+func ParseBackupMode(mode string) (BackupMode, error) {
+	switch BackupMode(mode) {
+	case BackupNone, BackupSimple, BackupNumbered:
+		return BackupMode(mode), nil
+	}
+	return BackupNone, fmt.Errorf("unknown backup mode '%s'", mode)
+}
+
+// Backup moves the entry name of dir aside and returns the backup name.
+// Simple backups are named 'file.txt~', numbered ones 'file.txt.~N~'.
+func Backup(dir *confine.Dir, name string, mode BackupMode) (string, error) {
+	var backupName string
+	switch mode {
+	case BackupSimple:
+		backupName = name + "~"
+	case BackupNumbered:
+		num, err := nextBackupNumber(dir, name)
+		if err != nil {
+			return "", err
+		}
+		backupName = fmt.Sprintf("%s.~%d~", name, num)
+	default:
+		return "", fmt.Errorf("unknown backup mode '%s'", mode)
+	}
+
+	if err := dir.Rename(name, backupName); err != nil {
+		return "", err
+	}
+	return backupName, nil
+}
+
+func nextBackupNumber(dir *confine.Dir, name string) (int, error) {
+	prefix := name + ".~"
+	names, err := dir.Names()
+	if err != nil {
+		return 0, err
+	}
+
+	max := 0
+	for _, entryName := range names {
+		if !strings.HasPrefix(entryName, prefix) || !strings.HasSuffix(entryName, "~") {
+			continue
+		}
+
+		num, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(entryName, prefix), "~"))
+		if err == nil && num > max {
+			max = num
+		}
+	}
+
+	return max + 1, nil
+}
+
+// CreateFree creates the entry name in dir, or the first free name like 'file (1).txt' if it already exists.
+// It returns the file and its final name.
+func CreateFree(dir *confine.Dir, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 1; ; i++ {
+		file, err := dir.Open(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			return file, candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, "", err
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+	}
+}
+
+// PartialName is the name a file which will be named name is written at until it is complete, a hidden file in the
+// same directory, like '.file.txt.3fa2b1c4.gsyn-part', so it can be renamed in place (see Place)
+func PartialName(name string) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return "." + name + "." + hex.EncodeToString(b) + PartialSuffix
+}
+
+// IsPartial reports whether the file name is of a file which is still being written
+func IsPartial(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, PartialSuffix)
+}
+
+// Place renames the complete file partialName of dir to name and returns its final name. An existing file
+// named name is backed up first unless mode is BackupNone, or with rename, it is kept and the file is placed
+// at the first free name like 'file (1).txt'. A replaced file keeps its permissions.
+func Place(dir *confine.Dir, partialName, name string, mode BackupMode, rename bool) (string, error) {
+	if rename {
+		reserved, freeName, err := CreateFree(dir, name)
+		if err != nil {
+			return "", err
+		}
+		reserved.Close()
+		if err = dir.Rename(partialName, freeName); err != nil {
+			dir.Remove(freeName)
+			return "", err
+		}
+		return freeName, nil
+	}
+
+	// a destination the policy refuses, like a symlink leading outside of the space, is not silently replaced
+	stat, err := dir.Stat(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if err == nil {
+		if err = dir.Chmod(partialName, stat.Mode().Perm()); err != nil {
+			return "", err
+		}
+		if mode != BackupNone {
+			if _, err = Backup(dir, name, mode); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err = dir.Rename(partialName, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,169 @@
+package conflict
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/aigic8/gosyn/api/confine"
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestDir(base string) *confine.Dir {
+	dir, err := confine.OpenDir(base, base, confine.SymlinksInside)
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+func TestBackup(t *testing.T) {
+	base := t.TempDir()
+	filePath := path.Join(base, "time.txt")
+	dir := openTestDir(base)
+	defer dir.Close()
+
+	for _, data := range []string{"ticking away", "the moments", "that make up a dull day"} {
+		if err := os.WriteFile(filePath, []byte(data), 0666); err != nil {
+			panic(err)
+		}
+
+		_, err := Backup(dir, "time.txt", BackupNumbered)
+		assert.Nil(t, err)
+	}
+
+	if err := os.WriteFile(filePath, []byte("fritter and waste"), 0666); err != nil {
+		panic(err)
+	}
+	backupName, err := Backup(dir, "time.txt", BackupSimple)
+	assert.Nil(t, err)
+	assert.Equal(t, backupName, "time.txt~")
+
+	assert.NoFileExists(t, filePath)
+	for i, name := range []string{"time.txt.~1~", "time.txt.~2~", "time.txt.~3~", "time.txt~"} {
+		data, err := os.ReadFile(path.Join(base, name))
+		assert.Nil(t, err)
+		assert.Equal(t, string(data), []string{"ticking away", "the moments", "that make up a dull day", "fritter and waste"}[i])
+	}
+}
+
+func TestCreateFree(t *testing.T) {
+	dir := openTestDir(t.TempDir())
+	defer dir.Close()
+
+	expected := []string{"time.txt", "time (1).txt", "time (2).txt"}
+	for _, name := range expected {
+		file, finalName, err := CreateFree(dir, "time.txt")
+		assert.Nil(t, err)
+		file.Close()
+		assert.Equal(t, finalName, name)
+	}
+}
+
+func TestPlace(t *testing.T) {
+	base := t.TempDir()
+	filePath := path.Join(base, "time.txt")
+	dir := openTestDir(base)
+	defer dir.Close()
+
+	write := func(data string) string {
+		partialName := PartialName("time.txt")
+		assert.True(t, IsPartial(partialName))
+		if err := os.WriteFile(path.Join(base, partialName), []byte(data), 0666); err != nil {
+			panic(err)
+		}
+		return partialName
+	}
+
+	placed, err := Place(dir, write("ticking away"), "time.txt", BackupNone, false)
+	assert.Nil(t, err)
+	assert.Equal(t, placed, "time.txt")
+
+	if err = os.Chmod(filePath, 0600); err != nil {
+		panic(err)
+	}
+	placed, err = Place(dir, write("the moments"), "time.txt", BackupSimple, false)
+	assert.Nil(t, err)
+	assert.Equal(t, placed, "time.txt")
+
+	placed, err = Place(dir, write("that make up a dull day"), "time.txt", BackupNone, true)
+	assert.Nil(t, err)
+	assert.Equal(t, placed, "time (1).txt")
+
+	stat, err := os.Stat(filePath)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.Mode().Perm(), os.FileMode(0600))
+
+	for name, expected := range map[string]string{"time.txt~": "ticking away", "time.txt": "the moments", "time (1).txt": "that make up a dull day"} {
+		data, err := os.ReadFile(path.Join(base, name))
+		assert.Nil(t, err)
+		assert.Equal(t, string(data), expected)
+	}
+
+	entries, err := os.ReadDir(base)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 3)
+	assert.False(t, IsPartial("time.txt"))
+}
+
+func TestPlaceMovedDir(t *testing.T) {
+	base := t.TempDir()
+	space := path.Join(base, "space")
+	if err := os.MkdirAll(path.Join(space, "dir"), 0777); err != nil {
+		panic(err)
+	}
+	dir, err := confine.OpenDir(space, path.Join(space, "dir"), confine.SymlinksInside)
+	if err != nil {
+		panic(err)
+	}
+	defer dir.Close()
+
+	// the directory is swapped for a symlink leading outside of the space after it is opened
+	if err = os.Rename(path.Join(space, "dir"), path.Join(space, "moved")); err != nil {
+		panic(err)
+	}
+	if err = os.Symlink(base, path.Join(space, "dir")); err != nil {
+		panic(err)
+	}
+
+	partialName := PartialName("time.txt")
+	file, err := dir.Open(partialName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	assert.Nil(t, err)
+	file.Close()
+	_, err = Place(dir, partialName, "time.txt", BackupNone, false)
+	assert.Nil(t, err)
+
+	assert.FileExists(t, path.Join(space, "moved/time.txt"))
+	assert.NoFileExists(t, path.Join(base, "time.txt"))
+}
+
+func TestPlaceRefusedDestination(t *testing.T) {
+	base := t.TempDir()
+	space := path.Join(base, "space")
+	if err := os.MkdirAll(space, 0777); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path.Join(base, "time.txt"), []byte("outside"), 0666); err != nil {
+		panic(err)
+	}
+	if err := os.Symlink(path.Join(base, "time.txt"), path.Join(space, "time.txt")); err != nil {
+		panic(err)
+	}
+	dir, err := confine.OpenDir(space, space, confine.SymlinksInside)
+	if err != nil {
+		panic(err)
+	}
+	defer dir.Close()
+
+	partialName := PartialName("time.txt")
+	file, err := dir.Open(partialName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	assert.Nil(t, err)
+	file.Close()
+	_, err = Place(dir, partialName, "time.txt", BackupSimple, false)
+	assert.True(t, confine.Refused(err))
+
+	target, err := os.Readlink(path.Join(space, "time.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, target, path.Join(base, "time.txt"))
+	assert.NoFileExists(t, path.Join(space, "time.txt~"))
+}
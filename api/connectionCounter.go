@@ -0,0 +1,40 @@
+package api
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"github.com/aigic8/gosyn/api/metrics"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// connectionCounter counts QUIC connections, it only implements the parts of the tracer it needs
+type connectionCounter struct {
+	logging.NullTracer
+	metrics *metrics.Server
+}
+
+func (c connectionCounter) TracerForConnection(context.Context, logging.Perspective, logging.ConnectionID) logging.ConnectionTracer {
+	return &connectionTracer{metrics: c.metrics}
+}
+
+type connectionTracer struct {
+	logging.NullConnectionTracer
+	metrics *metrics.Server
+	// started is false for connections closed before their first packet was decrypted
+	started atomic.Bool
+}
+
+func (t *connectionTracer) StartedConnection(local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
+	if t.started.CompareAndSwap(false, true) {
+		t.metrics.QUICConnections.Inc()
+		t.metrics.QUICConnectionsTotal.Inc()
+	}
+}
+
+func (t *connectionTracer) ClosedConnection(error) {
+	if t.started.CompareAndSwap(true, false) {
+		t.metrics.QUICConnections.Dec()
+	}
+}
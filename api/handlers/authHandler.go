@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aigic8/gosyn/api/authlimit"
+	"github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/token"
+	"google.golang.org/protobuf/proto"
+)
+
+type AuthHandler struct {
+	Users     map[string]utils.UserInfo
+	CertUsers utils.CertUsers
+	Signer    *token.Signer
+	Guard     *authlimit.Guard
+	Addresses utils.UserAddresses
+}
+
+// Login exchanges the GUID in 'simple <GUID>' authorization header, or the client certificate, for a session token
+func (h AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	scheme, credential, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme == "" {
+		credential = utils.PeerCertFingerprint(r)
+	}
+	if !utils.CheckAuthLimit(w, r, h.Guard, credential) {
+		return
+	}
+
+	user := utils.SimpleAuthUser(r, h.Users)
+	if user == nil {
+		user = h.CertUsers.User(r)
+	}
+	if user == nil {
+		utils.RefuseAuth(w, r, h.Guard, credential, utils.AuthFailureReason(scheme, credential), "bad authentication")
+		return
+	}
+	h.Guard.Succeed(credential)
+
+	if !h.Addresses.Check(w, r, user) {
+		return
+	}
+
+	spaces := make(map[string]string, len(user.Spaces))
+	for space, level := range user.Spaces {
+		spaces[space] = level.String()
+	}
+
+	claims := token.Claims{User: user.ID, Name: user.Name, Credential: user.Credential, Spaces: spaces}
+	if !user.ExpiresAt.IsZero() {
+		claims.ExpiresAt = user.ExpiresAt.Unix()
+	}
+	sessionToken, expiresAt, err := h.Signer.Issue(claims)
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
+
+	res := pb.AuthLoginResponse{Token: sessionToken, ExpiresAt: expiresAt.Unix()}
+	resBytes, err := proto.Marshal(&res)
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
+
+	w.Write(resBytes)
+}
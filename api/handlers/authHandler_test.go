@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api/access"
+	"github.com/aigic8/gosyn/api/authlimit"
+	"github.com/aigic8/gosyn/api/handlers/handlerstest"
+	"github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/token"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+type authLoginTestCase struct {
+	Name          string
+	Authorization string
+	Cert          *x509.Certificate
+	Status        int
+}
+
+func TestAuthLogin(t *testing.T) {
+	GUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	expiredGUID := "2f5f4a0e-5c1d-4e8e-9d4b-0c6f0f0f6a11"
+	users := map[string]utils.UserInfo{
+		GUID:        {ID: utils.UserID(GUID), Name: "peter", GUID: GUID, Spaces: map[string]access.Level{"spiderman": access.Write}},
+		expiredGUID: {ID: utils.UserID(GUID), Name: "peter", GUID: expiredGUID, Spaces: map[string]access.Level{"spiderman": access.Write}, ExpiresAt: time.Now().Add(-time.Hour)},
+	}
+	signer := token.NewSigner([]byte("with great power comes great responsibility"), time.Minute)
+
+	certUser := utils.UserInfo{ID: "cert", Spaces: map[string]access.Level{"spiderman": access.Write}}
+	userCert, _, err := handlerstest.MakeCert("peter")
+	if err != nil {
+		panic(err)
+	}
+	caCert, _, err := handlerstest.MakeCert("stark")
+	if err != nil {
+		panic(err)
+	}
+	strangerCert, _, err := handlerstest.MakeCert("stranger")
+	if err != nil {
+		panic(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	certUsers := utils.CertUsers{
+		ClientCAs:    clientCAs,
+		Fingerprints: map[string]utils.UserInfo{utils.CertFingerprint(userCert): certUser},
+		// self signed, so not verified by the CA
+		Subjects: map[string]utils.UserInfo{"CN=stranger": certUser},
+	}
+
+	testCases := []authLoginTestCase{
+		{Name: "normal", Authorization: "simple " + GUID, Status: http.StatusOK},
+		{Name: "unknownGUID", Authorization: "simple 2d4a5bd5-5e2b-4b1c-9c2c-5c4bfa1f1c70", Status: http.StatusUnauthorized},
+		{Name: "expiredGUID", Authorization: "simple " + expiredGUID, Status: http.StatusUnauthorized},
+		{Name: "badScheme", Authorization: "bearer " + GUID, Status: http.StatusUnauthorized},
+		{Name: "noHeader", Authorization: "", Status: http.StatusUnauthorized},
+		{Name: "certFingerprint", Cert: userCert, Status: http.StatusOK},
+		{Name: "certSubjectNotVerified", Cert: strangerCert, Status: http.StatusUnauthorized},
+	}
+
+	authHandler := AuthHandler{Users: users, CertUsers: certUsers, Signer: signer}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set("Authorization", tc.Authorization)
+			if tc.Cert != nil {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tc.Cert}}
+			}
+
+			authHandler.Login(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, res.StatusCode, tc.Status)
+
+			if res.StatusCode == http.StatusOK {
+				resBody, err := io.ReadAll(res.Body)
+				if err != nil {
+					panic(err)
+				}
+
+				var resData pb.AuthLoginResponse
+				if err := proto.Unmarshal(resBody, &resData); err != nil {
+					panic(err)
+				}
+
+				claims, err := signer.Verify(resData.Token)
+				assert.Nil(t, err)
+				if tc.Cert != nil {
+					assert.Equal(t, claims.User, certUser.ID)
+				} else {
+					assert.Equal(t, claims.User, utils.UserID(GUID))
+					assert.Equal(t, claims.Name, "peter")
+				}
+				assert.Equal(t, claims.Spaces, map[string]string{"spiderman": "write"})
+				assert.Equal(t, claims.ExpiresAt, resData.ExpiresAt)
+			}
+		})
+	}
+}
+
+func TestAuthLoginLimit(t *testing.T) {
+	GUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	users := map[string]utils.UserInfo{
+		GUID: {ID: utils.UserID(GUID), GUID: GUID, Spaces: map[string]access.Level{"spiderman": access.Write}},
+	}
+
+	opts := authlimit.DefaultOptions()
+	opts.MaxAddressFailures = 3
+	opts.MaxDelay = 0
+	authHandler := AuthHandler{
+		Users:  users,
+		Signer: token.NewSigner([]byte("with great power comes great responsibility"), time.Minute),
+		Guard:  authlimit.NewGuard(opts),
+	}
+
+	login := func(GUID string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Authorization", "simple "+GUID)
+		authHandler.Login(w, r)
+		return w.Result()
+	}
+
+	assert.Equal(t, login(GUID).StatusCode, http.StatusOK)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, login("2d4a5bd5-5e2b-4b1c-9c2c-5c4bfa1f1c70").StatusCode, http.StatusUnauthorized)
+	}
+
+	// the address is locked out, even for the valid credential, so it can not be found by guessing
+	res := login("7c1e7a9e-7d3f-4a55-8d8f-3f0c5b7a9d21")
+	assert.Equal(t, res.StatusCode, http.StatusTooManyRequests)
+	assert.NotEmpty(t, res.Header.Get("Retry-After"))
+	assert.Equal(t, login(GUID).StatusCode, http.StatusTooManyRequests)
+}
+
+func TestAuthLoginAddresses(t *testing.T) {
+	ciGUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	GUID := "2d4a5bd5-5e2b-4b1c-9c2c-5c4bfa1f1c70"
+	users := map[string]utils.UserInfo{
+		ciGUID: {ID: "ci", Name: "ci", GUID: ciGUID, Spaces: map[string]access.Level{"spiderman": access.Read}},
+		GUID:   {ID: "mj", Name: "mj", GUID: GUID, Spaces: map[string]access.Level{"spiderman": access.Write}},
+	}
+
+	ciRules, err := access.ParseAddressRules([]string{"10.8.0.0/16"}, nil)
+	if err != nil {
+		panic(err)
+	}
+	defaultRules, err := access.ParseAddressRules(nil, []string{"203.0.113.0/24"})
+	if err != nil {
+		panic(err)
+	}
+
+	authHandler := AuthHandler{
+		Users:     users,
+		Signer:    token.NewSigner([]byte("with great power comes great responsibility"), time.Minute),
+		Addresses: utils.UserAddresses{Users: map[string]access.AddressRules{"ci": ciRules}, Default: defaultRules},
+	}
+
+	login := func(GUID, remoteAddr string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Authorization", "simple "+GUID)
+		r.RemoteAddr = remoteAddr
+		authHandler.Login(w, r)
+		return w.Result().StatusCode
+	}
+
+	assert.Equal(t, login(ciGUID, "10.8.3.4:4433"), http.StatusOK)
+	assert.Equal(t, login(ciGUID, "192.0.2.1:4433"), http.StatusForbidden)
+	// the default rules do not apply to users with their own
+	assert.Equal(t, login(ciGUID, "203.0.113.5:4433"), http.StatusForbidden)
+	assert.Equal(t, login(GUID, "192.0.2.1:4433"), http.StatusOK)
+	assert.Equal(t, login(GUID, "203.0.113.5:4433"), http.StatusForbidden)
+}
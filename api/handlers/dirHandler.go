@@ -8,13 +8,18 @@ import (
 	"path"
 	"strings"
 
+	"github.com/aigic8/gosyn/api/access"
+	"github.com/aigic8/gosyn/api/audit"
+	"github.com/aigic8/gosyn/api/confine"
+	"github.com/aigic8/gosyn/api/conflict"
 	"github.com/aigic8/gosyn/api/handlers/utils"
 	"github.com/aigic8/gosyn/api/pb"
 	"google.golang.org/protobuf/proto"
 )
 
 type DirHandler struct {
-	Spaces map[string]string
+	Spaces       map[string]string
+	SpaceOptions map[string]utils.SpaceOptions
 }
 
 func (h DirHandler) GetList(w http.ResponseWriter, r *http.Request) {
@@ -24,21 +29,21 @@ func (h DirHandler) GetList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dirPath, spaceName, err := utils.SpacePathToNormalPath(rawPath, h.Spaces)
+	dirPath, err := utils.NewSpacePath(rawPath, h.Spaces)
 	if err != nil {
 		utils.WriteAPIErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	utils.Audit(r, audit.OpList, dirPath)
 
 	uInfo := r.Context().Value(utils.UserContextKey).(*utils.UserInfo)
-	if _, ok := uInfo.Spaces[spaceName]; !ok {
-		utils.WriteAPIErr(w, http.StatusUnauthorized, "unauthorized to access space")
+	if !utils.CheckAccess(w, uInfo, dirPath.Space, access.Read) {
 		return
 	}
 
-	isSubPath, err := utils.IsSubPath(h.Spaces[spaceName], dirPath)
+	isSubPath, err := dirPath.InSpace()
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -47,36 +52,50 @@ func (h DirHandler) GetList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stat, err := os.Stat(dirPath)
+	dir, err := confine.Open(dirPath.Root, dirPath.Disk, os.O_RDONLY, 0, h.SpaceOptions[dirPath.Space].Symlinks)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			utils.WriteAPIErr(w, http.StatusNotFound, fmt.Sprintf("path '%s' does not exist", dirPath))
+		} else if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", dirPath, errors.Unwrap(err)))
 		} else {
-			utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+			utils.WriteInternalErr(w, r, err)
 		}
 		return
 	}
+	defer dir.Close()
+
+	stat, err := dir.Stat()
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
 
 	if !stat.IsDir() {
 		utils.WriteAPIErr(w, http.StatusBadRequest, fmt.Sprintf("path '%s' is not a directory", dirPath))
 		return
 	}
 
-	rawChildren, err := os.ReadDir(dirPath)
+	span := utils.DiskSpan(r, "readdir", dirPath)
+	rawChildren, err := dir.ReadDir(-1)
+	utils.EndSpan(span, err)
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
 	children := make([]*pb.DirChild, 0, len(rawChildren))
 	for _, child := range rawChildren {
+		if conflict.IsPartial(child.Name()) {
+			continue
+		}
 		children = append(children, &pb.DirChild{Name: child.Name(), IsDir: child.IsDir()})
 	}
 
 	res := pb.DirGetListResponse{Children: children}
 	resBytes, err := proto.Marshal(&res)
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -91,21 +110,21 @@ func (h DirHandler) GetTree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dirPath, spaceName, err := utils.SpacePathToNormalPath(rawPath, h.Spaces)
+	dirPath, err := utils.NewSpacePath(rawPath, h.Spaces)
 	if err != nil {
 		utils.WriteAPIErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	utils.Audit(r, audit.OpTree, dirPath)
 
 	uInfo := r.Context().Value(utils.UserContextKey).(*utils.UserInfo)
-	if _, ok := uInfo.Spaces[spaceName]; !ok {
-		utils.WriteAPIErr(w, http.StatusUnauthorized, "unauthorized to access space")
+	if !utils.CheckAccess(w, uInfo, dirPath.Space, access.Read) {
 		return
 	}
 
-	isSubPath, err := utils.IsSubPath(h.Spaces[spaceName], dirPath)
+	isSubPath, err := dirPath.InSpace()
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -114,12 +133,15 @@ func (h DirHandler) GetTree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stat, err := os.Stat(dirPath)
+	// children of the tree which are symlinks are not walked into, so only its root needs confining
+	stat, err := confine.Stat(dirPath.Root, dirPath.Disk, h.SpaceOptions[dirPath.Space].Symlinks)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			utils.WriteAPIErr(w, http.StatusNotFound, fmt.Sprintf("path '%s' does not exist", dirPath))
+		} else if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", dirPath, errors.Unwrap(err)))
 		} else {
-			utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+			utils.WriteInternalErr(w, r, err)
 		}
 		return
 	}
@@ -129,22 +151,21 @@ func (h DirHandler) GetTree(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// FIXME show the path based space, maybe server does not want to reveal the full path
-	base, dirName := path.Split(dirPath)
-	t := map[string]*pb.TreeItem{
-		dirName: {
-			Path:     dirPath,
-			IsDir:    true,
-			Children: map[string]*pb.TreeItem{},
-		},
+	item := &pb.TreeItem{Path: dirPath.String(), IsDir: true, Children: map[string]*pb.TreeItem{}}
+	span := utils.DiskSpan(r, "walk", dirPath)
+	err = utils.FillTree(dirPath, item)
+	utils.EndSpan(span, err)
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
 	}
 
-	utils.FillTree(base, t)
+	t := map[string]*pb.TreeItem{path.Base(dirPath.String()): item}
 
 	res := pb.DirGetTreeResponse{Tree: t}
 	resBytes, err := proto.Marshal(&res)
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
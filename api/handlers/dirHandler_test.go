@@ -8,6 +8,7 @@ import (
 	"path"
 	"testing"
 
+	"github.com/aigic8/gosyn/api/access"
 	"github.com/aigic8/gosyn/api/handlers/handlerstest"
 	"github.com/aigic8/gosyn/api/handlers/utils"
 	"github.com/aigic8/gosyn/api/pb"
@@ -61,7 +62,7 @@ func TestDirGetList(t *testing.T) {
 	}
 	dirHandler := DirHandler{Spaces: spaces}
 
-	userSpaces := map[string]bool{"seethers": true}
+	userSpaces := map[string]access.Level{"seethers": access.Write}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -76,6 +77,7 @@ func TestDirGetList(t *testing.T) {
 			r = r.WithContext(ctx)
 
 			dirHandler.GetList(w, r)
+			assert.NotContains(t, w.Body.String(), base, "response reveals where spaces are on the server")
 
 			res := w.Result()
 			defer res.Body.Close()
@@ -129,21 +131,20 @@ func TestDirGetTree(t *testing.T) {
 		panic(err)
 	}
 
-	normalBase := path.Join(base, "space/seethers")
 	normalTree := map[string]*pb.TreeItem{
 		"seethers": {
-			Path:  normalBase,
+			Path:  "seethers",
 			IsDir: true,
 			Children: map[string]*pb.TreeItem{
 				"special": {
-					Path:  path.Join(normalBase, "/special"),
+					Path:  "seethers/special",
 					IsDir: true,
 					Children: map[string]*pb.TreeItem{
-						"save-today.txt": {Path: path.Join(normalBase, "/special/save-today.txt"), IsDir: false},
+						"save-today.txt": {Path: "seethers/special/save-today.txt", IsDir: false},
 					},
 				},
 				"truth.txt": {
-					Path:  path.Join(normalBase, "/truth.txt"),
+					Path:  "seethers/truth.txt",
 					IsDir: false,
 				},
 			},
@@ -164,7 +165,7 @@ func TestDirGetTree(t *testing.T) {
 	}
 	dirHandler := DirHandler{Spaces: spaces}
 
-	userSpaces := map[string]bool{"seethers": true}
+	userSpaces := map[string]access.Level{"seethers": access.Write}
 
 	for _, tc := range testCases {
 		w := httptest.NewRecorder()
@@ -178,6 +179,7 @@ func TestDirGetTree(t *testing.T) {
 		r = r.WithContext(ctx)
 
 		dirHandler.GetTree(w, r)
+		assert.NotContains(t, w.Body.String(), base, "response reveals where spaces are on the server")
 
 		res := w.Result()
 		defer res.Body.Close()
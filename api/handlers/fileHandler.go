@@ -1,24 +1,33 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 
+	"github.com/aigic8/gosyn/api/access"
+	"github.com/aigic8/gosyn/api/audit"
+	"github.com/aigic8/gosyn/api/confine"
+	"github.com/aigic8/gosyn/api/conflict"
 	"github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/metrics"
 	"github.com/aigic8/gosyn/api/pb"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type FileHandler struct {
-	Spaces map[string]string
+	Spaces       map[string]string
+	SpaceOptions map[string]utils.SpaceOptions
+	Limits       utils.Limits
 }
 
 func (h FileHandler) Get(w http.ResponseWriter, r *http.Request) {
@@ -28,21 +37,21 @@ func (h FileHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath, spaceName, err := utils.SpacePathToNormalPath(rawPath, h.Spaces)
+	filePath, err := utils.NewSpacePath(rawPath, h.Spaces)
 	if err != nil {
 		utils.WriteAPIErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	utils.Audit(r, audit.OpDownload, filePath)
 
 	uInfo := r.Context().Value(utils.UserContextKey).(*utils.UserInfo)
-	if _, ok := uInfo.Spaces[spaceName]; !ok {
-		utils.WriteAPIErr(w, http.StatusUnauthorized, "unauthorized to access space")
+	if !utils.CheckAccess(w, uInfo, filePath.Space, access.Read) {
 		return
 	}
 
-	isSubPath, err := utils.IsSubPath(h.Spaces[spaceName], filePath)
+	isSubPath, err := filePath.InSpace()
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -51,12 +60,14 @@ func (h FileHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := os.Open(filePath)
+	file, err := confine.Open(filePath.Root, filePath.Disk, os.O_RDONLY, 0, h.SpaceOptions[filePath.Space].Symlinks)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			utils.WriteAPIErr(w, http.StatusNotFound, fmt.Sprintf("path '%s' does not exist", filePath))
+		} else if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", filePath, errors.Unwrap(err)))
 		} else {
-			utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+			utils.WriteInternalErr(w, r, err)
 		}
 		return
 	}
@@ -64,7 +75,7 @@ func (h FileHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 	stat, err := file.Stat()
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -75,13 +86,25 @@ func (h FileHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
 
-	io.Copy(w, file)
+	defer utils.StartTransfer(r, metrics.Download)()
+	span := utils.DiskSpan(r, "read", filePath)
+	n, err := io.Copy(utils.MeterWriter(r, filePath.Space, w), file)
+	span.SetAttr("bytes", n)
+	utils.EndSpan(span, err)
+	utils.AuditBytes(r, n)
 }
 
 func (h FileHandler) PutNew(w http.ResponseWriter, r *http.Request) {
 	rawPath := strings.TrimSpace(r.Header.Get("x-file-path"))
 	srcName := strings.TrimSpace(r.Header.Get("x-src-name"))
 	isForced := r.Header.Get("x-force") == "true"
+	renameOnConflict := r.Header.Get("x-rename-on-conflict") == "true"
+
+	backupMode, err := conflict.ParseBackupMode(strings.TrimSpace(r.Header.Get("x-backup")))
+	if err != nil {
+		utils.WriteAPIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	if rawPath == "" {
 		utils.WriteAPIErr(w, http.StatusBadRequest, "file path is required")
@@ -93,26 +116,33 @@ func (h FileHandler) PutNew(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if strings.ContainsRune(srcName, os.PathListSeparator) {
-		utils.WriteAPIErr(w, http.StatusBadRequest, fmt.Sprintf("source name can not contain '%s'", string(os.PathSeparator)))
+	// the name is joined to the destination directory, so it must not lead anywhere else
+	if strings.ContainsRune(srcName, '/') || strings.ContainsRune(srcName, os.PathSeparator) {
+		utils.WriteAPIErr(w, http.StatusBadRequest, "source name can not contain '/'")
 		return
 	}
 
-	destPath, spaceName, err := utils.SpacePathToNormalPath(rawPath, h.Spaces)
+	destPath, err := utils.NewSpacePath(rawPath, h.Spaces)
 	if err != nil {
 		utils.WriteAPIErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	utils.Audit(r, audit.OpUpload, destPath)
 
 	uInfo := r.Context().Value(utils.UserContextKey).(*utils.UserInfo)
-	if _, ok := uInfo.Spaces[spaceName]; !ok {
-		utils.WriteAPIErr(w, http.StatusUnauthorized, "unauthorized to access space")
+	if !utils.CheckAccess(w, uInfo, destPath.Space, access.Create) {
+		return
+	}
+
+	maxSize := h.Limits.MaxUploadSize
+	if maxSize > 0 && r.ContentLength > maxSize {
+		utils.WriteAPIErr(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than the upload limit of %d bytes", maxSize))
 		return
 	}
 
-	isSubPath, err := utils.IsSubPath(h.Spaces[spaceName], destPath)
+	isSubPath, err := destPath.InSpace()
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -121,45 +151,61 @@ func (h FileHandler) PutNew(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dirMode := false
+	spaceOptions := h.SpaceOptions[destPath.Space]
+	if backupMode == conflict.BackupNone {
+		backupMode = spaceOptions.Backup
+	}
+	if !isForced && spaceOptions.RenameOnConflict {
+		renameOnConflict = true
+	}
+
 	wPath := destPath
-	fileStat, err := os.Stat(destPath)
+	fileStat, err := confine.Stat(destPath.Root, destPath.Disk, spaceOptions.Symlinks)
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+		if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", destPath, errors.Unwrap(err)))
 			return
 		}
-	} else {
-		if fileStat.IsDir() {
-			dirMode = true
-			wPath = path.Join(destPath, srcName)
+		if !errors.Is(err, os.ErrNotExist) {
+			utils.WriteInternalErr(w, r, err)
+			return
 		}
+	} else if fileStat.IsDir() {
+		wPath = destPath.Join(srcName)
 	}
 
-	if !dirMode {
-		parentPath := path.Dir(destPath)
-		parentStat, err := os.Stat(parentPath)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				utils.WriteAPIErr(w, http.StatusBadRequest, fmt.Sprintf("parent dir '%s' does not exist", parentPath))
-				return
-			}
-			utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+	// the directory is opened once and the upload is written, backed up and renamed relative to it, so it can
+	// not be moved outside of the space in between
+	parentPath := wPath.Dir()
+	dir, err := confine.OpenDir(parentPath.Root, parentPath.Disk, spaceOptions.Symlinks)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			utils.WriteAPIErr(w, http.StatusBadRequest, fmt.Sprintf("parent dir '%s' does not exist", parentPath))
 			return
 		}
-
-		if !parentStat.IsDir() {
+		if errors.Is(err, syscall.ENOTDIR) {
 			utils.WriteAPIErr(w, http.StatusBadRequest, fmt.Sprintf("parent dir '%s' is not a directory", parentPath))
 			return
 		}
+		if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", parentPath, errors.Unwrap(err)))
+			return
+		}
+		utils.WriteInternalErr(w, r, err)
+		return
 	}
+	defer dir.Close()
+	name := filepath.Base(wPath.Disk)
 
-	// FIXME check for if path is in space space
-
-	wStat, err := os.Stat(wPath)
+	wExists := false
+	wStat, err := dir.Stat(name)
 	if err != nil {
+		if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", wPath, errors.Unwrap(err)))
+			return
+		}
 		if !errors.Is(err, os.ErrNotExist) {
-			utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+			utils.WriteInternalErr(w, r, err)
 			return
 		}
 	} else { // path does exist
@@ -167,25 +213,95 @@ func (h FileHandler) PutNew(w http.ResponseWriter, r *http.Request) {
 			utils.WriteAPIErr(w, http.StatusBadRequest, fmt.Sprintf("path '%s' is a directory", wPath))
 			return
 		}
-		if !isForced {
+		if !isForced && !renameOnConflict {
 			utils.WriteAPIErr(w, http.StatusBadRequest, fmt.Sprintf("path '%s' already exists", wPath))
 			return
 		}
+		wExists = true
+	}
+
+	operation := audit.OpUpload
+	if wExists && !renameOnConflict {
+		operation = audit.OpOverwrite
+	}
+	utils.Audit(r, operation, wPath)
+
+	if operation == audit.OpOverwrite && !utils.CheckAccess(w, uInfo, wPath.Space, access.Write) {
+		return
+	}
+
+	// the upload is written next to its destination and moved there only once it is complete, so a failed
+	// or interrupted upload never leaves a truncated file in place of the old one
+	partialName := conflict.PartialName(name)
+	file, err := dir.Open(partialName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
+
+	// the size of chunked uploads is only known once they are read
+	var body io.Reader = r.Body
+	if maxSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	done := utils.StartTransfer(r, metrics.Upload)
+	span := utils.DiskSpan(r, "write", wPath)
+	n, err := io.Copy(file, utils.MeterReader(r, wPath.Space, body))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	span.SetAttr("bytes", n)
+	utils.EndSpan(span, err)
+	done()
+	utils.AuditBytes(r, n)
+	if err != nil {
+		dir.Remove(partialName)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.WriteAPIErr(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("file is larger than the upload limit of %d bytes", maxSize))
+			return
+		}
+		utils.WriteInternalErr(w, r, err)
+		return
 	}
 
-	file, err := os.Create(wPath)
+	placeBackup := conflict.BackupNone
+	if wExists {
+		placeBackup = backupMode
+	}
+	span = utils.DiskSpan(r, "commit", wPath)
+	name, err = conflict.Place(dir, partialName, name, placeBackup, wExists && renameOnConflict)
+	utils.EndSpan(span, err)
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+		dir.Remove(partialName)
+		// the destination was swapped for a refused symlink during the upload
+		if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", wPath, errors.Unwrap(err)))
+			return
+		}
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
-	defer file.Close()
+	wPath = parentPath.Join(name)
+	if wExists && renameOnConflict {
+		utils.Audit(r, operation, wPath)
+	}
+
+	resp := pb.FilePutNewResponse{
+		Path: wPath.String(),
+	}
 
-	if _, err = io.Copy(file, r.Body); err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+	respProto, err := proto.Marshal(&resp)
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
-	w.Write([]byte{})
+	w.Write(respProto)
 }
 
 func (h FileHandler) Match(w http.ResponseWriter, r *http.Request) {
@@ -195,19 +311,21 @@ func (h FileHandler) Match(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pattern, spaceName, err := utils.SpacePathToNormalPath(rawPath, h.Spaces)
+	pattern, err := utils.NewSpacePath(rawPath, h.Spaces)
 	if err != nil {
 		utils.WriteAPIErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	utils.Audit(r, audit.OpMatch, pattern)
 
 	uInfo := r.Context().Value(utils.UserContextKey).(*utils.UserInfo)
-	if _, ok := uInfo.Spaces[spaceName]; !ok {
-		utils.WriteAPIErr(w, http.StatusUnauthorized, "unauthorized to access space")
+	if !utils.CheckAccess(w, uInfo, pattern.Space, access.Read) {
 		return
 	}
 
-	matchedPaths, err := filepath.Glob(pattern)
+	span := utils.DiskSpan(r, "glob", pattern)
+	matchedPaths, err := filepath.Glob(pattern.Disk)
+	utils.EndSpan(span, err)
 	if err != nil {
 		// as said in https://pkg.go.dev/path/filepath#Glob the only error is for malformed patterns
 		utils.WriteAPIErr(w, http.StatusBadRequest, "malformed pattern: "+err.Error())
@@ -215,11 +333,10 @@ func (h FileHandler) Match(w http.ResponseWriter, r *http.Request) {
 	}
 
 	matchedFiles := []string{}
-	spacePath := h.Spaces[spaceName]
 	for _, matchedPath := range matchedPaths {
-		isSubPath, err := utils.IsSubPath(spacePath, matchedPath)
+		isSubPath, err := utils.IsSubPath(pattern.Root, matchedPath)
 		if err != nil {
-			utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+			utils.WriteInternalErr(w, r, err)
 			return
 		}
 
@@ -228,22 +345,24 @@ func (h FileHandler) Match(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		stat, err := os.Stat(matchedPath)
-		// FIXME maybe only not return paths with error instead of returning internal server error?
+		// Glob follows every symlink, paths the policy does not allow are left out like missing ones
+		stat, err := confine.Stat(pattern.Root, matchedPath, h.SpaceOptions[pattern.Space].Symlinks)
 		if err != nil {
-			utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+			if confine.Refused(err) || errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			utils.WriteInternalErr(w, r, err)
 			return
 		}
 
-		normalPathPrefix := h.Spaces[spaceName]
-		if !stat.IsDir() {
-			newFile := path.Join(spaceName, strings.TrimPrefix(matchedPath, normalPathPrefix))
-			matchedFiles = append(matchedFiles, newFile)
+		if !stat.IsDir() && !conflict.IsPartial(stat.Name()) {
+			matchedFiles = append(matchedFiles, pattern.Rel(matchedPath))
 		}
 	}
 
 	if len(matchedFiles) == 0 {
 		utils.WriteAPIErr(w, http.StatusNotFound, "no matches found")
+		return
 	}
 
 	resp := pb.FileGetMatchResponse{
@@ -252,7 +371,7 @@ func (h FileHandler) Match(w http.ResponseWriter, r *http.Request) {
 
 	respProto, err := proto.Marshal(&resp)
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -268,21 +387,21 @@ func (h FileHandler) Stat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath, spaceName, err := utils.SpacePathToNormalPath(rawPath, h.Spaces)
+	filePath, err := utils.NewSpacePath(rawPath, h.Spaces)
 	if err != nil {
 		utils.WriteAPIErr(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	utils.Audit(r, audit.OpStat, filePath)
 
 	uInfo := r.Context().Value(utils.UserContextKey).(*utils.UserInfo)
-	if _, ok := uInfo.Spaces[spaceName]; !ok {
-		utils.WriteAPIErr(w, http.StatusUnauthorized, "unauthorized to access space")
+	if !utils.CheckAccess(w, uInfo, filePath.Space, access.Read) {
 		return
 	}
 
-	isSubPath, err := utils.IsSubPath(h.Spaces[spaceName], filePath)
+	isSubPath, err := filePath.InSpace()
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -291,13 +410,19 @@ func (h FileHandler) Stat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stat, err := os.Stat(filePath)
+	span := utils.DiskSpan(r, "stat", filePath)
+	stat, err := confine.Stat(filePath.Root, filePath.Disk, h.SpaceOptions[filePath.Space].Symlinks)
+	utils.EndSpan(span, err)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			utils.WriteAPIErr(w, http.StatusNotFound, err.Error())
+			utils.WriteAPIErr(w, http.StatusNotFound, fmt.Sprintf("path '%s' does not exist", filePath))
+			return
+		}
+		if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", filePath, errors.Unwrap(err)))
 			return
 		}
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -312,7 +437,84 @@ func (h FileHandler) Stat(w http.ResponseWriter, r *http.Request) {
 
 	respProto, err := proto.Marshal(&resp)
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
+
+	w.Write(respProto)
+}
+
+func (h FileHandler) Hash(w http.ResponseWriter, r *http.Request) {
+	rawPath := strings.TrimSpace(r.URL.Query().Get("path"))
+	if rawPath == "" {
+		utils.WriteAPIErr(w, http.StatusBadRequest, "path is required")
+		return
+	}
+
+	filePath, err := utils.NewSpacePath(rawPath, h.Spaces)
+	if err != nil {
+		utils.WriteAPIErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	utils.Audit(r, audit.OpHash, filePath)
+
+	uInfo := r.Context().Value(utils.UserContextKey).(*utils.UserInfo)
+	if !utils.CheckAccess(w, uInfo, filePath.Space, access.Read) {
+		return
+	}
+
+	isSubPath, err := filePath.InSpace()
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
+
+	if !isSubPath {
+		utils.WriteAPIErr(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	file, err := confine.Open(filePath.Root, filePath.Disk, os.O_RDONLY, 0, h.SpaceOptions[filePath.Space].Symlinks)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			utils.WriteAPIErr(w, http.StatusNotFound, fmt.Sprintf("path '%s' does not exist", filePath))
+		} else if confine.Refused(err) {
+			utils.WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("path '%s' is not allowed: %s", filePath, errors.Unwrap(err)))
+		} else {
+			utils.WriteInternalErr(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
+
+	if stat.IsDir() {
+		utils.WriteAPIErr(w, http.StatusBadRequest, fmt.Sprintf("path '%s' is a directory", filePath))
+		return
+	}
+
+	hash := sha256.New()
+	span := utils.DiskSpan(r, "hash", filePath)
+	_, err = io.Copy(hash, file)
+	utils.EndSpan(span, err)
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
+
+	resp := pb.FileGetHashResponse{
+		Algorithm: "sha256",
+		Hash:      hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	respProto, err := proto.Marshal(&resp)
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
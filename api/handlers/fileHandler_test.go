@@ -3,6 +3,11 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -12,11 +17,17 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/aigic8/gosyn/api/access"
+	"github.com/aigic8/gosyn/api/audit"
 	"github.com/aigic8/gosyn/api/handlers/handlerstest"
 	"github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/logging"
+	"github.com/aigic8/gosyn/api/metrics"
 	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/tracing"
 )
 
 type fileGetTestCase struct {
@@ -48,6 +59,14 @@ func TestFileGet(t *testing.T) {
 		panic(err)
 	}
 
+	err = handlerstest.MakeSymlinks(base, map[string]string{
+		"space/seethers/inside.txt":  "truth.txt",
+		"space/seethers/outside.txt": "../../outsider.txt",
+	})
+	if err != nil {
+		panic(err)
+	}
+
 	normalStat, err := os.Stat(path.Join(base, "space/seethers/truth.txt"))
 	if err != nil {
 		panic(err)
@@ -59,6 +78,8 @@ func TestFileGet(t *testing.T) {
 		{Name: "pathTraversal", Status: http.StatusUnauthorized, Path: "seethers/../../outsider.txt"},
 		{Name: "pathIsDir", Status: http.StatusBadRequest, Path: "seethers/dir"},
 		{Name: "notExists", Status: http.StatusNotFound, Path: "seethers/what-would-you-do.txt"},
+		{Name: "symlinkInside", Status: http.StatusOK, Path: "seethers/inside.txt", Data: seetherTruthData, ContentLength: normalStat.Size()},
+		{Name: "symlinkOutside", Status: http.StatusForbidden, Path: "seethers/outside.txt"},
 	}
 
 	spaces := map[string]string{
@@ -67,7 +88,7 @@ func TestFileGet(t *testing.T) {
 	}
 	fileHandler := FileHandler{Spaces: spaces}
 
-	userSpaces := map[string]bool{"seethers": true}
+	userSpaces := map[string]access.Level{"seethers": access.Write}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
 			w := httptest.NewRecorder()
@@ -85,6 +106,7 @@ func TestFileGet(t *testing.T) {
 			r = r.WithContext(ctx)
 
 			fileHandler.Get(w, r)
+			assert.NotContains(t, w.Body.String(), base, "response reveals where spaces are on the server")
 
 			res := w.Result()
 			defer res.Body.Close()
@@ -112,6 +134,12 @@ type filePutNewTestCase struct {
 	NewFileData []byte
 	RawFilePath string
 	IsForce     bool
+	Backup      string
+	Rename      bool
+	BackupPath  string
+	ResPath     string
+	// Level is the access level of the user to pink-floyd, access.Write if not set
+	Level access.Level
 }
 
 func TestFilePutNew(t *testing.T) {
@@ -127,6 +155,15 @@ func TestFilePutNew(t *testing.T) {
 
 	err = handlerstest.MakeFiles(base, []handlerstest.FileInfo{
 		{Path: "space/pink-floyd/time.txt", Data: []byte("The time is gone, the song is over, thought I'd something more to say")},
+		{Path: "outsider.txt", Data: []byte("I am an outsider.")},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	err = handlerstest.MakeSymlinks(base, map[string]string{
+		"space/pink-floyd/outside":     "../..",
+		"space/pink-floyd/outside.txt": "../../outsider.txt",
 	})
 	if err != nil {
 		panic(err)
@@ -146,8 +183,18 @@ func TestFilePutNew(t *testing.T) {
 		{Name: "pathTraversal", Status: http.StatusUnauthorized, NewFilePath: pathTraversalPath, SrcName: "wish-you-were-here.txt", NewFileData: newFileData},
 		{Name: "fileExistsNormal", Status: http.StatusBadRequest, NewFilePath: "pink-floyd/time.txt", SrcName: "time.txt", NewFileData: []byte("Home, home again.")},
 		{Name: "fileExistsForce", Status: http.StatusOK, NewFilePath: "pink-floyd/time.txt", SrcName: "time.txt", NewFileData: fileExistsForceFileData, RawFilePath: "space/pink-floyd/time.txt", IsForce: true},
+		{Name: "fileExistsBackup", Status: http.StatusOK, NewFilePath: "pink-floyd/time.txt", SrcName: "time.txt", NewFileData: []byte("The sun is the same in a relative way"), RawFilePath: "space/pink-floyd/time.txt", IsForce: true, Backup: "numbered", BackupPath: "space/pink-floyd/time.txt.~1~"},
+		{Name: "fileExistsRename", Status: http.StatusOK, NewFilePath: "pink-floyd/time.txt", SrcName: "time.txt", NewFileData: []byte("but you're older"), RawFilePath: "space/pink-floyd/time (1).txt", Rename: true, ResPath: "pink-floyd/time (1).txt"},
+		{Name: "badBackupMode", Status: http.StatusBadRequest, NewFilePath: "pink-floyd/time.txt", SrcName: "time.txt", NewFileData: newFileData, IsForce: true, Backup: "always"},
+		{Name: "srcNameWithSeparator", Status: http.StatusBadRequest, NewFilePath: "pink-floyd", SrcName: "../outsider.txt", NewFileData: newFileData},
 		{Name: "fileIsDir", Status: http.StatusBadRequest, NewFilePath: "pink-floyd", SrcName: "old", NewFileData: newFileData},
 		{Name: "unauthorizedSpace", Status: http.StatusUnauthorized, NewFilePath: "seethers/truth.txt", SrcName: "truth.txt", NewFileData: []byte("No, there's nothing you say that can salvage the lie")},
+		{Name: "readOnly", Status: http.StatusForbidden, NewFilePath: "pink-floyd/money.txt", SrcName: "money.txt", NewFileData: []byte("Money, get away"), Level: access.Read},
+		{Name: "createOnlyNew", Status: http.StatusOK, NewFilePath: "pink-floyd/money.txt", SrcName: "money.txt", NewFileData: []byte("Money, get away"), RawFilePath: "space/pink-floyd/money.txt", Level: access.Create},
+		{Name: "createOnlyOverwrite", Status: http.StatusForbidden, NewFilePath: "pink-floyd/money.txt", SrcName: "money.txt", NewFileData: []byte("Get a good job with more pay"), IsForce: true, Level: access.Create},
+		{Name: "createOnlyRename", Status: http.StatusOK, NewFilePath: "pink-floyd/money.txt", SrcName: "money.txt", NewFileData: []byte("and you're okay"), RawFilePath: "space/pink-floyd/money (1).txt", Rename: true, Level: access.Create},
+		{Name: "symlinkOutsideDir", Status: http.StatusForbidden, NewFilePath: "pink-floyd/outside", SrcName: "us-and-them.txt", NewFileData: []byte("Us, and them")},
+		{Name: "symlinkOutsideFile", Status: http.StatusForbidden, NewFilePath: "pink-floyd/outside.txt", SrcName: "outside.txt", NewFileData: []byte("And after all we're only ordinary men"), IsForce: true},
 	}
 
 	spaces := map[string]string{
@@ -156,9 +203,14 @@ func TestFilePutNew(t *testing.T) {
 	}
 	fileHandler := FileHandler{Spaces: spaces}
 
-	userSpaces := map[string]bool{"pink-floyd": true}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
+			level := tc.Level
+			if level == access.None {
+				level = access.Write
+			}
+			userSpaces := map[string]access.Level{"pink-floyd": level}
+
 			w := httptest.NewRecorder()
 
 			r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(tc.NewFileData))
@@ -167,6 +219,12 @@ func TestFilePutNew(t *testing.T) {
 			if tc.IsForce {
 				r.Header.Add("x-force", "true")
 			}
+			if tc.Backup != "" {
+				r.Header.Add("x-backup", tc.Backup)
+			}
+			if tc.Rename {
+				r.Header.Add("x-rename-on-conflict", "true")
+			}
 
 			uInfo := utils.UserInfo{
 				GUID:   "f3b1f1cb-d1e6-4700-8f96-c28182563729",
@@ -176,6 +234,7 @@ func TestFilePutNew(t *testing.T) {
 			r = r.WithContext(ctx)
 
 			fileHandler.PutNew(w, r)
+			assert.NotContains(t, w.Body.String(), base, "response reveals where spaces are on the server")
 
 			res := w.Result()
 			assert.Equal(t, res.StatusCode, tc.Status)
@@ -189,9 +248,27 @@ func TestFilePutNew(t *testing.T) {
 				fileBytes, err := io.ReadAll(newFile)
 				assert.Nil(t, err)
 				assert.Equal(t, tc.NewFileData, fileBytes)
+
+				if tc.BackupPath != "" {
+					assert.FileExists(t, path.Join(base, tc.BackupPath))
+				}
+
+				if tc.ResPath != "" {
+					resBody, err := io.ReadAll(res.Body)
+					assert.Nil(t, err)
+
+					var resData pb.FilePutNewResponse
+					assert.Nil(t, proto.Unmarshal(resBody, &resData))
+					assert.Equal(t, resData.Path, tc.ResPath)
+				}
 			}
 		})
 	}
+
+	assert.NoFileExists(t, path.Join(base, "us-and-them.txt"))
+	outsiderData, err := os.ReadFile(path.Join(base, "outsider.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(outsiderData), "I am an outsider.")
 }
 
 type fileMatchTestCase struct {
@@ -220,6 +297,11 @@ func TestFileMatch(t *testing.T) {
 		panic(err)
 	}
 
+	err = handlerstest.MakeSymlinks(base, map[string]string{"space/pink-floyd/outsider.txt": "../../outsider.txt"})
+	if err != nil {
+		panic(err)
+	}
+
 	// TODO add test cases:
 	// - space does not exist (maybe)
 	normalCaseFiles := []string{
@@ -243,7 +325,7 @@ func TestFileMatch(t *testing.T) {
 	fileHandler := FileHandler{
 		Spaces: spaces,
 	}
-	userSpaces := map[string]bool{"pink-floyd": true}
+	userSpaces := map[string]access.Level{"pink-floyd": access.Write}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -258,6 +340,7 @@ func TestFileMatch(t *testing.T) {
 			r = r.WithContext(ctx)
 
 			fileHandler.Match(w, r)
+			assert.NotContains(t, w.Body.String(), base, "response reveals where spaces are on the server")
 
 			res := w.Result()
 			defer res.Body.Close()
@@ -326,7 +409,7 @@ func TestFileStat(t *testing.T) {
 		Spaces: spaces,
 	}
 
-	userSpaces := map[string]bool{"pink-floyd": true}
+	userSpaces := map[string]access.Level{"pink-floyd": access.Write}
 
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -341,6 +424,7 @@ func TestFileStat(t *testing.T) {
 			r = r.WithContext(ctx)
 
 			fileHandler.Stat(w, r)
+			assert.NotContains(t, w.Body.String(), base, "response reveals where spaces are on the server")
 
 			res := w.Result()
 			defer res.Body.Close()
@@ -366,3 +450,420 @@ func TestFileStat(t *testing.T) {
 	}
 
 }
+
+type fileHashTestCase struct {
+	Name   string
+	Status int
+	Path   string
+	Hash   string
+}
+
+func TestFileHash(t *testing.T) {
+	base := t.TempDir()
+
+	err := handlerstest.MakeDirs(base, []string{
+		"space/pink-floyd/special",
+		"space/seethers",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	timeData := []byte("Plans that either come to naught or half a page of scribbled lines")
+	err = handlerstest.MakeFiles(base, []handlerstest.FileInfo{
+		{Path: "space/pink-floyd/time.txt", Data: timeData},
+		{Path: "space/seethers/truth.txt", Data: []byte("The deception you show is your own parasite")},
+		{Path: "outsider.txt", Data: []byte("I am an outsider.")},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	timeHash := sha256.Sum256(timeData)
+	testCases := []fileHashTestCase{
+		{Name: "normal", Status: http.StatusOK, Path: "pink-floyd/time.txt", Hash: hex.EncodeToString(timeHash[:])},
+		{Name: "pathTraversal", Status: http.StatusUnauthorized, Path: "pink-floyd/../../outsider.txt"},
+		{Name: "pathIsDir", Status: http.StatusBadRequest, Path: "pink-floyd/special"},
+		{Name: "notExists", Status: http.StatusNotFound, Path: "pink-floyd/wish-you-were-here.txt"},
+		{Name: "unauthorizedSpace", Status: http.StatusUnauthorized, Path: "seethers/truth.txt"},
+	}
+
+	spaces := map[string]string{
+		"pink-floyd": path.Join(base, "space/pink-floyd"),
+		"seethers":   path.Join(base, "space/seethers"),
+	}
+	fileHandler := FileHandler{
+		Spaces: spaces,
+	}
+
+	userSpaces := map[string]access.Level{"pink-floyd": access.Write}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/?path="+tc.Path, nil)
+
+			uInfo := utils.UserInfo{
+				GUID:   "f3b1f1cb-d1e6-4700-8f96-c28182563729",
+				Spaces: userSpaces,
+			}
+			ctx := context.WithValue(r.Context(), utils.UserContextKey, &uInfo)
+			r = r.WithContext(ctx)
+
+			fileHandler.Hash(w, r)
+			assert.NotContains(t, w.Body.String(), base, "response reveals where spaces are on the server")
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, res.StatusCode, tc.Status)
+
+			if res.StatusCode == http.StatusOK {
+				resBody, err := io.ReadAll(res.Body)
+				if err != nil {
+					panic(err)
+				}
+
+				var resData pb.FileGetHashResponse
+				if err = proto.Unmarshal(resBody, &resData); err != nil {
+					panic(err)
+				}
+
+				assert.Equal(t, resData.Algorithm, "sha256")
+				assert.Equal(t, resData.Hash, tc.Hash)
+			}
+		})
+	}
+
+}
+
+func TestFileAudit(t *testing.T) {
+	base := t.TempDir()
+
+	err := handlerstest.MakeDirs(base, []string{"space/pink-floyd"})
+	if err != nil {
+		panic(err)
+	}
+
+	timeData := []byte("Ticking away the moments that make up a dull day")
+	err = handlerstest.MakeFiles(base, []handlerstest.FileInfo{
+		{Path: "space/pink-floyd/time.txt", Data: timeData},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fileHandler := FileHandler{Spaces: map[string]string{"pink-floyd": path.Join(base, "space/pink-floyd")}}
+	recorder := &handlerstest.AuditRecorder{}
+	auditMiddleware := utils.AuditMiddleware(recorder)
+
+	serve := func(handler http.HandlerFunc, r *http.Request, level access.Level) {
+		uInfo := utils.UserInfo{ID: "roger", Name: "roger", Credential: "laptop", Spaces: map[string]access.Level{"pink-floyd": level}}
+		r = r.WithContext(context.WithValue(r.Context(), utils.UserContextKey, &uInfo))
+		r.RemoteAddr = "1.2.3.4:5678"
+		auditMiddleware(handler).ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	serve(fileHandler.Get, httptest.NewRequest(http.MethodGet, "/?path=pink-floyd/time.txt", nil), access.Read)
+
+	newData := []byte("Breathe, breathe in the air")
+	r := httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(newData))
+	r.Header.Add("x-file-path", "pink-floyd/breathe.txt")
+	r.Header.Add("x-src-name", "breathe.txt")
+	serve(fileHandler.PutNew, r, access.Create)
+
+	r = httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(newData))
+	r.Header.Add("x-file-path", "pink-floyd/time.txt")
+	r.Header.Add("x-src-name", "time.txt")
+	r.Header.Add("x-force", "true")
+	serve(fileHandler.PutNew, r, access.Create)
+
+	// not audited, since there is no space to tell the path in
+	serve(fileHandler.Get, httptest.NewRequest(http.MethodGet, "/?path=the-wall/brick.txt", nil), access.Read)
+
+	assert.Equal(t, len(recorder.Events), 3)
+	for _, event := range recorder.Events {
+		assert.Equal(t, event.User, "roger")
+		assert.Equal(t, event.Credential, "laptop")
+		assert.Equal(t, event.RemoteAddr, "1.2.3.4:5678")
+	}
+
+	download := recorder.Events[0]
+	assert.Equal(t, download.Operation, audit.OpDownload)
+	assert.Equal(t, download.Path, "pink-floyd/time.txt")
+	assert.Equal(t, download.Bytes, int64(len(timeData)))
+	assert.Equal(t, download.Result, "ok")
+
+	upload := recorder.Events[1]
+	assert.Equal(t, upload.Operation, audit.OpUpload)
+	assert.Equal(t, upload.Path, "pink-floyd/breathe.txt")
+	assert.Equal(t, upload.Bytes, int64(len(newData)))
+	assert.Equal(t, upload.Status, http.StatusOK)
+
+	overwrite := recorder.Events[2]
+	assert.Equal(t, overwrite.Operation, audit.OpOverwrite)
+	assert.Equal(t, overwrite.Path, "pink-floyd/time.txt")
+	assert.Equal(t, overwrite.Result, "denied")
+	assert.Equal(t, overwrite.Status, http.StatusForbidden)
+}
+
+func TestFileRequestLog(t *testing.T) {
+	base := t.TempDir()
+
+	err := handlerstest.MakeDirs(base, []string{"space/pink-floyd"})
+	if err != nil {
+		panic(err)
+	}
+
+	err = handlerstest.MakeFiles(base, []handlerstest.FileInfo{
+		{Path: "space/pink-floyd/time.txt", Data: []byte("Ticking away the moments that make up a dull day")},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fileHandler := FileHandler{Spaces: map[string]string{"pink-floyd": path.Join(base, "space/pink-floyd")}}
+	recorder := &handlerstest.AuditRecorder{}
+	logs := &bytes.Buffer{}
+	logHandler, err := logging.NewHandler(logs, logging.Options{Format: logging.FormatJSON})
+	if err != nil {
+		panic(err)
+	}
+	handler := utils.RequestLogMiddleware(slog.New(logHandler))(utils.AuditMiddleware(recorder)(http.HandlerFunc(fileHandler.Get)))
+
+	serve := func(requestID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/?path=pink-floyd/time.txt", nil)
+		uInfo := utils.UserInfo{ID: "roger", Name: "roger", Spaces: map[string]access.Level{"pink-floyd": access.Read}}
+		r = r.WithContext(context.WithValue(r.Context(), utils.UserContextKey, &uInfo))
+		if requestID != "" {
+			r.Header.Set(logging.RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve("")
+	generatedID := w.Header().Get(logging.RequestIDHeader)
+	assert.Len(t, generatedID, 16)
+
+	w = serve("backup-job-42")
+	assert.Equal(t, w.Header().Get(logging.RequestIDHeader), "backup-job-42")
+
+	// IDs which could break log lines are replaced
+	w = serve("bad id\n")
+	assert.NotEqual(t, w.Header().Get(logging.RequestIDHeader), "bad id\n")
+	assert.Len(t, w.Header().Get(logging.RequestIDHeader), 16)
+
+	assert.Equal(t, len(recorder.Events), 3)
+	assert.Equal(t, recorder.Events[0].RequestID, generatedID)
+	assert.Equal(t, recorder.Events[1].RequestID, "backup-job-42")
+
+	assert.Contains(t, logs.String(), `"request_id":"`+generatedID+`"`)
+	assert.Contains(t, logs.String(), `"request_id":"backup-job-42"`)
+	assert.Contains(t, logs.String(), `"status":200`)
+}
+
+func TestFileMetrics(t *testing.T) {
+	base := t.TempDir()
+
+	err := handlerstest.MakeDirs(base, []string{"space/pink-floyd"})
+	if err != nil {
+		panic(err)
+	}
+
+	timeData := []byte("Ticking away the moments that make up a dull day")
+	err = handlerstest.MakeFiles(base, []handlerstest.FileInfo{
+		{Path: "space/pink-floyd/time.txt", Data: timeData},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fileHandler := FileHandler{Spaces: map[string]string{"pink-floyd": path.Join(base, "space/pink-floyd")}}
+	serverMetrics := metrics.NewServer()
+	r := chi.NewRouter()
+	r.Use(utils.MetricsMiddleware(serverMetrics))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uInfo := utils.UserInfo{ID: "roger", Name: "roger", Spaces: map[string]access.Level{"pink-floyd": access.Create}}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), utils.UserContextKey, &uInfo)))
+		})
+	})
+	r.Route("/api/files", func(r chi.Router) {
+		r.Get("/", fileHandler.Get)
+		r.Put("/new", fileHandler.PutNew)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/files/?path=pink-floyd/time.txt", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/files/?path=pink-floyd/none.txt", nil))
+
+	newData := []byte("Breathe, breathe in the air")
+	req := httptest.NewRequest(http.MethodPut, "/api/files/new", bytes.NewReader(newData))
+	req.Header.Add("x-file-path", "pink-floyd/breathe.txt")
+	req.Header.Add("x-src-name", "breathe.txt")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREATHE", "/nowhere", nil))
+
+	out := &bytes.Buffer{}
+	_, err = serverMetrics.Registry.WriteTo(out)
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), `gsyn_http_requests_total{route="/api/files",method="GET",status="200"} 1`)
+	assert.Contains(t, out.String(), `gsyn_http_requests_total{route="/api/files",method="GET",status="404"} 1`)
+	assert.Contains(t, out.String(), `gsyn_http_requests_total{route="/api/files/new",method="PUT",status="200"} 1`)
+	assert.Contains(t, out.String(), `gsyn_http_requests_total{route="unmatched",method="GET",status="404"} 1`)
+	assert.Contains(t, out.String(), `gsyn_http_requests_total{route="unmatched",method="other",status="405"} 1`)
+	assert.NotContains(t, out.String(), "BREATHE")
+	assert.Contains(t, out.String(), fmt.Sprintf(`gsyn_file_bytes_sent_total{space="pink-floyd",user="roger"} %d`, len(timeData)))
+	assert.Contains(t, out.String(), fmt.Sprintf(`gsyn_file_bytes_received_total{space="pink-floyd",user="roger"} %d`, len(newData)))
+	assert.Contains(t, out.String(), `gsyn_active_transfers{direction="download"} 0`)
+}
+
+func TestFileTracing(t *testing.T) {
+	base := t.TempDir()
+
+	err := handlerstest.MakeDirs(base, []string{"space/pink-floyd"})
+	if err != nil {
+		panic(err)
+	}
+
+	err = handlerstest.MakeFiles(base, []handlerstest.FileInfo{
+		{Path: "space/pink-floyd/time.txt", Data: []byte("Ticking away the moments that make up a dull day")},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	out := &bytes.Buffer{}
+	tracer := tracing.NewTracer("gsyn-server", tracing.NewWriterExporter(out))
+	fileHandler := FileHandler{Spaces: map[string]string{"pink-floyd": path.Join(base, "space/pink-floyd")}}
+	r := chi.NewRouter()
+	r.Use(utils.TracingMiddleware(tracer))
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uInfo := utils.UserInfo{ID: "roger", Name: "roger", Spaces: map[string]access.Level{"pink-floyd": access.Read}}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), utils.UserContextKey, &uInfo)))
+		})
+	})
+	r.Route("/api/files", func(r chi.Router) {
+		r.Get("/", fileHandler.Get)
+	})
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest(http.MethodGet, "/api/files/?path=pink-floyd/time.txt", nil)
+	req.Header.Set(tracing.TraceparentHeader, traceparent)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Nil(t, tracer.Close())
+
+	spans := map[string]tracing.JSONSpan{}
+	decoder := json.NewDecoder(out)
+	for decoder.More() {
+		var span tracing.JSONSpan
+		assert.Nil(t, decoder.Decode(&span))
+		spans[span.Name] = span
+	}
+
+	server, ok := spans["GET /api/files"]
+	assert.True(t, ok)
+	assert.Equal(t, server.TraceID, "4bf92f3577b34da6a3ce929d0e0e4736")
+	assert.Equal(t, server.ParentSpanID, "00f067aa0ba902b7")
+	assert.Equal(t, server.Kind, "server")
+	assert.Equal(t, server.Attributes["http.status_code"], float64(http.StatusOK))
+
+	disk, ok := spans["disk read"]
+	assert.True(t, ok)
+	assert.Equal(t, disk.ParentSpanID, server.SpanID)
+	assert.Equal(t, disk.Attributes["path"], "pink-floyd/time.txt")
+}
+
+func TestFilePutNewLimit(t *testing.T) {
+	base := t.TempDir()
+
+	err := handlerstest.MakeDirs(base, []string{"space/pink-floyd"})
+	if err != nil {
+		panic(err)
+	}
+
+	fileHandler := FileHandler{
+		Spaces: map[string]string{"pink-floyd": path.Join(base, "space/pink-floyd")},
+		Limits: utils.Limits{MaxUploadSize: 16},
+	}
+	uInfo := utils.UserInfo{ID: "roger", Name: "roger", Spaces: map[string]access.Level{"pink-floyd": access.Create}}
+
+	put := func(data []byte, chunked bool) *http.Response {
+		var body io.Reader = bytes.NewReader(data)
+		if chunked {
+			// a reader of unknown size, so the upload is not rejected before it is read
+			body = io.MultiReader(body)
+		}
+		r := httptest.NewRequest(http.MethodPut, "/api/files/new", body)
+		r.Header.Add("x-file-path", "pink-floyd/breathe.txt")
+		r.Header.Add("x-src-name", "breathe.txt")
+		r.Header.Add("x-force", "true")
+		r = r.WithContext(context.WithValue(r.Context(), utils.UserContextKey, &uInfo))
+
+		w := httptest.NewRecorder()
+		fileHandler.PutNew(w, r)
+		return w.Result()
+	}
+
+	res := put([]byte("Breathe, breathe in the air"), false)
+	assert.Equal(t, res.StatusCode, http.StatusRequestEntityTooLarge)
+	_, err = os.Stat(path.Join(base, "space/pink-floyd/breathe.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	res = put([]byte("Breathe, breathe in the air"), true)
+	assert.Equal(t, res.StatusCode, http.StatusRequestEntityTooLarge)
+	_, err = os.Stat(path.Join(base, "space/pink-floyd/breathe.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	res = put([]byte("Don't be afraid"), false)
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+}
+
+type failingReader struct{ data []byte }
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if len(fr.data) == 0 {
+		return 0, errors.New("connection lost")
+	}
+	n := copy(p, fr.data)
+	fr.data = fr.data[n:]
+	return n, nil
+}
+
+func TestFilePutNewInterrupted(t *testing.T) {
+	base := t.TempDir()
+
+	err := handlerstest.MakeDirs(base, []string{"space/pink-floyd"})
+	if err != nil {
+		panic(err)
+	}
+	err = handlerstest.MakeFiles(base, []handlerstest.FileInfo{{Path: "space/pink-floyd/time.txt", Data: []byte("Ticking away the moments")}})
+	if err != nil {
+		panic(err)
+	}
+
+	fileHandler := FileHandler{Spaces: map[string]string{"pink-floyd": path.Join(base, "space/pink-floyd")}}
+	uInfo := utils.UserInfo{ID: "roger", Name: "roger", Spaces: map[string]access.Level{"pink-floyd": access.Write}}
+
+	r := httptest.NewRequest(http.MethodPut, "/api/files/new", &failingReader{data: []byte("Kicking around")})
+	r.Header.Add("x-file-path", "pink-floyd/time.txt")
+	r.Header.Add("x-src-name", "time.txt")
+	r.Header.Add("x-force", "true")
+	r = r.WithContext(context.WithValue(r.Context(), utils.UserContextKey, &uInfo))
+
+	w := httptest.NewRecorder()
+	fileHandler.PutNew(w, r)
+	assert.Equal(t, w.Result().StatusCode, http.StatusInternalServerError)
+
+	data, err := os.ReadFile(path.Join(base, "space/pink-floyd/time.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "Ticking away the moments")
+
+	entries, err := os.ReadDir(path.Join(base, "space/pink-floyd"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 1)
+}
@@ -3,6 +3,9 @@ package handlerstest
 import (
 	"os"
 	"path"
+	"sync"
+
+	"github.com/aigic8/gosyn/api/audit"
 )
 
 type FileInfo struct {
@@ -31,3 +34,27 @@ func MakeDirs(base string, dirs []string) error {
 
 	return nil
 }
+
+// MakeSymlinks creates symlinks at the keys of links, pointing to their values
+func MakeSymlinks(base string, links map[string]string) error {
+	for link, target := range links {
+		err := os.Symlink(target, path.Join(base, link))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AuditRecorder keeps audit events in memory
+type AuditRecorder struct {
+	mu     sync.Mutex
+	Events []audit.Event
+}
+
+func (a *AuditRecorder) Log(event audit.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.Events = append(a.Events, event)
+}
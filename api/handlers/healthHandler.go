@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readyCacheTTL is how long the result of checking the spaces is kept, so probes can not make the server write to
+// every space on each request
+const readyCacheTTL = 5 * time.Second
+
+// HealthHandler answers probes of load balancers and orchestrators, without authentication
+type HealthHandler struct {
+	Spaces map[string]string
+	// Detail lists the spaces which are not ready by name, it should only be set where their names are not public
+	Detail bool
+	ready  *readyCache
+}
+
+type readyCache struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	problems  []string
+}
+
+func NewHealthHandler(spaces map[string]string, detail bool) HealthHandler {
+	return HealthHandler{Spaces: spaces, Detail: detail, ready: &readyCache{}}
+}
+
+// Healthz reports the server is running
+func (h HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// Readyz reports whether every space is a directory the server can write to. With Detail, failures are listed by
+// space name, never with disk paths.
+func (h HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	problems := h.readyProblems()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(problems) == 0 {
+		w.Write([]byte("ok\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if !h.Detail {
+		w.Write([]byte("not ready\n"))
+		return
+	}
+	w.Write([]byte(strings.Join(problems, "\n") + "\n"))
+}
+
+// readyProblems checks the spaces at most once every readyCacheTTL, probes coming in while they are checked wait for it
+func (h HealthHandler) readyProblems() []string {
+	h.ready.mu.Lock()
+	defer h.ready.mu.Unlock()
+
+	if !h.ready.checkedAt.IsZero() && time.Since(h.ready.checkedAt) < readyCacheTTL {
+		return h.ready.problems
+	}
+
+	names := make([]string, 0, len(h.Spaces))
+	for name := range h.Spaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := []string{}
+	for _, name := range names {
+		if err := CheckSpaceWritable(h.Spaces[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("space '%s': %s", name, err))
+		}
+	}
+
+	h.ready.checkedAt, h.ready.problems = time.Now(), problems
+	return problems
+}
+
+// CheckSpaceWritable creates and removes a file in the space, its errors do not have the path of the space
+func CheckSpaceWritable(spacePath string) error {
+	stat, err := os.Stat(spacePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errors.New("does not exist")
+		}
+		return errors.New("can not be accessed")
+	}
+	if !stat.IsDir() {
+		return errors.New("is not a directory")
+	}
+
+	f, err := os.CreateTemp(spacePath, ".gsyn-readyz-*")
+	if err != nil {
+		return errors.New("is not writable")
+	}
+	f.Close()
+	if err = os.Remove(f.Name()); err != nil {
+		return errors.New("probe file can not be removed")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api/handlers/handlerstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	HealthHandler{}.Healthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+	assert.Equal(t, w.Body.String(), "ok\n")
+}
+
+func TestReadyz(t *testing.T) {
+	base := t.TempDir()
+
+	err := handlerstest.MakeDirs(base, []string{"space/pink-floyd"})
+	if err != nil {
+		panic(err)
+	}
+
+	err = handlerstest.MakeFiles(base, []handlerstest.FileInfo{
+		{Path: "space/time.txt", Data: []byte("Ticking away the moments that make up a dull day")},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	w := httptest.NewRecorder()
+	healthHandler := NewHealthHandler(map[string]string{"pink-floyd": path.Join(base, "space/pink-floyd")}, true)
+	healthHandler.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	// the probe file is removed
+	entries, err := os.ReadDir(path.Join(base, "space/pink-floyd"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 0)
+
+	healthHandler.Spaces["seethers"] = path.Join(base, "space/seethers")
+	healthHandler.Spaces["time"] = path.Join(base, "space/time.txt")
+
+	// the result is kept for a while
+	w = httptest.NewRecorder()
+	healthHandler.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, w.Code, http.StatusOK)
+
+	healthHandler.ready.checkedAt = time.Now().Add(-readyCacheTTL)
+	w = httptest.NewRecorder()
+	healthHandler.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	res := w.Result()
+	body, err := io.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, res.StatusCode, http.StatusServiceUnavailable)
+	assert.Equal(t, string(body), "space 'seethers': does not exist\nspace 'time': is not a directory\n")
+
+	// without detail, space names are not revealed
+	publicHandler := NewHealthHandler(healthHandler.Spaces, false)
+	w = httptest.NewRecorder()
+	publicHandler.Readyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+	assert.Equal(t, w.Body.String(), "not ready\n")
+}
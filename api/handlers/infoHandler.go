@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/info"
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/token"
+	"google.golang.org/protobuf/proto"
+)
+
+type InfoHandler struct {
+	Limits utils.Limits
+	Signer *token.Signer
+}
+
+// Get tells clients the version, protocol version, features and limits of the server
+func (h InfoHandler) Get(w http.ResponseWriter, r *http.Request) {
+	res := pb.InfoGetResponse{
+		Version:            info.Version,
+		ProtocolVersion:    info.ProtocolVersion,
+		MinProtocolVersion: info.MinProtocolVersion,
+		Features:           info.Features,
+		Limits: &pb.InfoLimits{
+			MaxUploadSize: h.Limits.MaxUploadSize,
+			TokenTTL:      int64(h.Signer.TTL().Seconds()),
+		},
+	}
+
+	resBytes, err := proto.Marshal(&res)
+	if err != nil {
+		utils.WriteInternalErr(w, r, err)
+		return
+	}
+
+	w.Write(resBytes)
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/info"
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/token"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestInfoGet(t *testing.T) {
+	infoHandler := InfoHandler{
+		Limits: utils.Limits{MaxUploadSize: 1024 * 1024},
+		Signer: token.NewSigner([]byte("a-secret-of-at-least-32-characters!"), 15*time.Minute),
+	}
+
+	w := httptest.NewRecorder()
+	infoHandler.Get(w, httptest.NewRequest(http.MethodGet, "/api/info", nil))
+
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var resData pb.InfoGetResponse
+	err = proto.Unmarshal(resBody, &resData)
+	assert.Nil(t, err)
+	assert.Equal(t, resData.Version, info.Version)
+	assert.Equal(t, resData.ProtocolVersion, int32(info.ProtocolVersion))
+	assert.Equal(t, resData.MinProtocolVersion, int32(info.MinProtocolVersion))
+	assert.Equal(t, resData.Features, info.Features)
+	assert.Equal(t, resData.Limits.MaxUploadSize, int64(1024*1024))
+	assert.Equal(t, resData.Limits.TokenTTL, int64(900))
+}
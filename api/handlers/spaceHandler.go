@@ -14,14 +14,16 @@ func (h SpaceHandler) GetAll(w http.ResponseWriter, r *http.Request) {
 	userInfo := r.Context().Value(utils.UserContextKey).(*utils.UserInfo)
 
 	spaces := make([]string, 0, len(userInfo.Spaces))
-	for space := range userInfo.Spaces {
+	levels := make(map[string]string, len(userInfo.Spaces))
+	for space, level := range userInfo.Spaces {
 		spaces = append(spaces, space)
+		levels[space] = level.String()
 	}
 
-	res := pb.SpaceGetAllResponse{Spaces: spaces}
+	res := pb.SpaceGetAllResponse{Spaces: spaces, Levels: levels}
 	resBytes, err := proto.Marshal(&res)
 	if err != nil {
-		utils.WriteAPIErr(w, http.StatusInternalServerError, "internal server error happened")
+		utils.WriteInternalErr(w, r, err)
 		return
 	}
 
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/aigic8/gosyn/api/access"
 	"github.com/aigic8/gosyn/api/handlers/utils"
 	"github.com/aigic8/gosyn/api/pb"
 	"github.com/stretchr/testify/assert"
@@ -17,18 +18,19 @@ type spaceGetAllTestCase struct {
 	Name   string
 	Status int
 	Spaces []string
+	Levels map[string]string
 }
 
 func TestSpaceGetAll(t *testing.T) {
 
-	userSpaces := map[string]bool{"spiderman": true, "batman": true}
+	userSpaces := map[string]access.Level{"spiderman": access.Write, "batman": access.Read}
 	spaces := make([]string, 0, len(userSpaces))
 	for space := range userSpaces {
 		spaces = append(spaces, space)
 	}
 
 	testCases := []spaceGetAllTestCase{
-		{Name: "normal", Status: http.StatusOK, Spaces: spaces},
+		{Name: "normal", Status: http.StatusOK, Spaces: spaces, Levels: map[string]string{"spiderman": "write", "batman": "read"}},
 	}
 
 	spaceHandler := SpaceHandler{}
@@ -62,6 +64,7 @@ func TestSpaceGetAll(t *testing.T) {
 				}
 
 				assert.ElementsMatch(t, resData.Spaces, tc.Spaces)
+				assert.Equal(t, resData.Levels, tc.Levels)
 			}
 		})
 	}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Drainer tracks the requests in flight, so a server can stop taking new ones and wait for the rest before exiting
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	active   int
+	idle     chan struct{}
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{idle: make(chan struct{})}
+}
+
+// Middleware counts requests as in flight until their handlers return.
+// While draining, new requests are refused with 503 so clients can retry another server or later.
+func (d *Drainer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		if d.draining {
+			d.mu.Unlock()
+			WriteAPIErr(w, http.StatusServiceUnavailable, "server is shutting down")
+			return
+		}
+		d.active++
+		d.mu.Unlock()
+
+		defer d.done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Drainer) done() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active--
+	if d.draining && d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// Active is the number of requests in flight
+func (d *Drainer) Active() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active
+}
+
+// Drain refuses new requests and waits for the ones in flight to finish, or for ctx to be done,
+// then the error of ctx is returned. It can be called again to keep waiting.
+func (d *Drainer) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		if d.active == 0 {
+			close(d.idle)
+		}
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-d.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainer(t *testing.T) {
+	d := NewDrainer()
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	finished := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/files/new", nil))
+		finished <- w.Code
+	}()
+	<-started
+	assert.Equal(t, d.Active(), 1)
+
+	// the request in flight outlives the first grace period
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Drain(ctx), context.DeadlineExceeded)
+
+	// new requests are refused while draining
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/files/stat", nil))
+	assert.Equal(t, w.Code, http.StatusServiceUnavailable)
+
+	close(release)
+	assert.Equal(t, <-finished, http.StatusOK)
+	assert.Nil(t, d.Drain(context.Background()))
+	assert.Equal(t, d.Active(), 0)
+}
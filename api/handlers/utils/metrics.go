@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aigic8/gosyn/api/authlimit"
+	"github.com/aigic8/gosyn/api/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// MetricsMiddleware counts requests and their durations by route, and lets handlers count file bytes
+// (see MeterReader and MeterWriter). It should wrap the router, so routes are known when requests are done.
+// A nil m collects nothing.
+func MetricsMiddleware(m *metrics.Server) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if m == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), MetricsContextKey, m)))
+
+			// chi fills the route pattern of the request context while routing
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			status := strconv.Itoa(sw.status)
+			method := metricsMethod(r.Method)
+			m.Requests.Inc(route, method, status)
+			m.RequestDuration.Observe(time.Since(start).Seconds(), route, method, status)
+		})
+	}
+}
+
+// MeterReader counts the file bytes read from reader as received into the space by the user of the request
+func MeterReader(r *http.Request, space string, reader io.Reader) io.Reader {
+	m, ok := r.Context().Value(MetricsContextKey).(*metrics.Server)
+	if !ok {
+		return reader
+	}
+	return &meteredReader{reader: reader, counter: m.BytesReceived, labels: []string{space, metricsUser(r)}}
+}
+
+// MeterWriter counts the file bytes written to writer as sent from the space to the user of the request
+func MeterWriter(r *http.Request, space string, writer io.Writer) io.Writer {
+	m, ok := r.Context().Value(MetricsContextKey).(*metrics.Server)
+	if !ok {
+		return writer
+	}
+	return &meteredWriter{writer: writer, counter: m.BytesSent, labels: []string{space, metricsUser(r)}}
+}
+
+// StartTransfer counts a transfer in the direction as active until the returned function is called
+func StartTransfer(r *http.Request, direction string) func() {
+	m, ok := r.Context().Value(MetricsContextKey).(*metrics.Server)
+	if !ok {
+		return func() {}
+	}
+	m.ActiveTransfers.Inc(direction)
+	return func() { m.ActiveTransfers.Dec(direction) }
+}
+
+// AuthFailed reports a failed authentication to the guard and counts it
+func AuthFailed(r *http.Request, guard *authlimit.Guard, credential, reason string) {
+	guard.Fail(r, credential, reason)
+	if m, ok := r.Context().Value(MetricsContextKey).(*metrics.Server); ok {
+		m.AuthFailures.Inc()
+	}
+}
+
+// metricsMethod is the method label of requests, any method clients make up is 'other' so they can not grow the
+// number of series without bound
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "other"
+}
+
+func metricsUser(r *http.Request) string {
+	if user, ok := r.Context().Value(UserContextKey).(*UserInfo); ok {
+		return user.Name
+	}
+	return ""
+}
+
+type meteredReader struct {
+	reader  io.Reader
+	counter *metrics.CounterVec
+	labels  []string
+}
+
+func (m *meteredReader) Read(b []byte) (int, error) {
+	n, err := m.reader.Read(b)
+	if n > 0 {
+		m.counter.Add(float64(n), m.labels...)
+	}
+	return n, err
+}
+
+type meteredWriter struct {
+	writer  io.Writer
+	counter *metrics.CounterVec
+	labels  []string
+}
+
+func (m *meteredWriter) Write(b []byte) (int, error) {
+	n, err := m.writer.Write(b)
+	if n > 0 {
+		m.counter.Add(float64(n), m.labels...)
+	}
+	return n, err
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/aigic8/gosyn/api/logging"
+	"github.com/aigic8/gosyn/api/tracing"
+	"golang.org/x/exp/slog"
+)
+
+// maxRequestIDLen limits IDs picked by clients, longer ones are replaced
+const maxRequestIDLen = 64
+
+// RequestLogMiddleware gives every request an ID, returned in logging.RequestIDHeader, and a logger with the ID
+// for handlers (see Logger). Each request is logged when it is done. A nil logger is slog.Default().
+// Inside TracingMiddleware, logs of the request also have the trace ID.
+func RequestLogMiddleware(logger *slog.Logger) func(http.Handler) http.Handler {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(logging.RequestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(logging.RequestIDHeader, id)
+
+			reqLogger := logger.With("request_id", id)
+			if span := tracing.SpanFromContext(r.Context()); span != nil {
+				reqLogger = reqLogger.With("trace_id", span.SpanContext().TraceID().String())
+				span.SetAttr("request_id", id)
+			}
+			ctx := context.WithValue(r.Context(), RequestIDContextKey, id)
+			ctx = slog.NewContext(ctx, reqLogger)
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			reqLogger.Info("request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", sw.status,
+				"bytes", sw.bytes,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"remote_addr", r.RemoteAddr,
+			)
+		})
+	}
+}
+
+// Logger is the logger of the request, with its ID
+func Logger(r *http.Request) *slog.Logger {
+	return slog.FromContext(r.Context())
+}
+
+// RequestID is the ID of the request, or empty outside of RequestLogMiddleware
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(RequestIDContextKey).(string)
+	return id
+}
+
+// WriteInternalErr logs err and writes an error which does not tell the client about it
+func WriteInternalErr(w http.ResponseWriter, r *http.Request, err error) {
+	Logger(r).Error("internal server error", err, "path", r.URL.Path)
+	WriteAPIErr(w, http.StatusInternalServerError, "internal server error")
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs which can not break log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
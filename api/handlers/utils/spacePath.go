@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+// SpacePath is a path in a space. It formats as the clients name it, 'space/sub/file', so
+// putting it in responses and errors never reveals where spaces are on the server.
+type SpacePath struct {
+	Space string
+	// Root is the directory of the space on the server
+	Root string
+	// Disk is the path on the server, only to be passed to file system calls
+	Disk string
+}
+
+// NewSpacePath resolves a path like 'space/sub/file' from a client
+func NewSpacePath(rawPath string, spaces map[string]string) (SpacePath, error) {
+	spaceName, filePath, err := SplitSpaceAndPath(rawPath)
+	if err != nil {
+		return SpacePath{}, fmt.Errorf("bad path: %v", err)
+	}
+
+	spaceRoot, ok := spaces[spaceName]
+	if !ok {
+		return SpacePath{}, errors.New("space does not exist")
+	}
+
+	return SpacePath{Space: spaceName, Root: spaceRoot, Disk: path.Join(spaceRoot, filePath)}, nil
+}
+
+func (p SpacePath) String() string {
+	return p.Rel(p.Disk)
+}
+
+// Rel translates diskPath, a path in the space on the server, to the way clients name it
+func (p SpacePath) Rel(diskPath string) string {
+	rel, err := filepath.Rel(p.Root, diskPath)
+	if err != nil {
+		return p.Space
+	}
+	return path.Join(p.Space, filepath.ToSlash(rel))
+}
+
+// Join returns the path of name in the directory p
+func (p SpacePath) Join(name string) SpacePath {
+	p.Disk = path.Join(p.Disk, name)
+	return p
+}
+
+// Dir returns the parent directory of p
+func (p SpacePath) Dir() SpacePath {
+	p.Disk = path.Dir(p.Disk)
+	return p
+}
+
+// InSpace reports whether p is lexically inside the space, symlinks are checked by the confine package
+func (p SpacePath) InSpace() (bool, error) {
+	return IsSubPath(p.Root, p.Disk)
+}
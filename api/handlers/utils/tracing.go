@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aigic8/gosyn/api/tracing"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingMiddleware starts a server span for every request, continuing the trace of the client if it sent
+// tracing.TraceparentHeader. It should wrap the router, so spans are named after routes. A nil tracer traces nothing.
+func TracingMiddleware(tracer *tracing.Tracer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if tracer == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tracer.Start(tracing.Extract(r.Context(), r.Header), r.Method, trace.WithSpanKind(trace.SpanKindServer))
+			defer span.End()
+			span.SetAttr("http.method", r.Method)
+			span.SetAttr("http.target", r.URL.Path)
+			span.SetAttr("net.peer.addr", r.RemoteAddr)
+
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, r.WithContext(ctx))
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+			span.SetName(r.Method + " " + route)
+			span.SetAttr("http.route", route)
+			span.SetAttr("http.status_code", sw.status)
+			if sw.status >= http.StatusInternalServerError {
+				span.SetError(fmt.Errorf("status %d", sw.status))
+			}
+		})
+	}
+}
+
+// DiskSpan starts a span of disk I/O on p for the request, like 'disk read'. It records nothing outside of TracingMiddleware.
+func DiskSpan(r *http.Request, operation string, p SpacePath) *tracing.Span {
+	_, span := tracing.Start(r.Context(), "disk "+operation)
+	span.SetAttr("path", p.String())
+	return span
+}
+
+// EndSpan records err, if any, on span and ends it
+func EndSpan(span *tracing.Span, err error) {
+	span.SetError(err)
+	span.End()
+}
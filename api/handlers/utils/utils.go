@@ -2,15 +2,27 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/aigic8/gosyn/api/access"
+	"github.com/aigic8/gosyn/api/audit"
+	"github.com/aigic8/gosyn/api/authlimit"
+	"github.com/aigic8/gosyn/api/confine"
+	"github.com/aigic8/gosyn/api/conflict"
 	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/token"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -18,77 +30,432 @@ type RequestContextKey int
 
 const (
 	UserContextKey RequestContextKey = iota
+	AuditContextKey
+	RequestIDContextKey
+	MetricsContextKey
 )
 
 type UserInfo struct {
+	// ID identifies the user in session tokens and logs, unlike GUID it is not a secret
+	ID string
+	// Name is the configured name of the user, or ID for users without one
+	Name   string
 	GUID   string
-	Spaces map[string]bool
+	Spaces map[string]access.Level
+	// Credential is the label of the credential the user authenticated with
+	Credential string
+	// ExpiresAt is when the credential stops being accepted, zero for never
+	ExpiresAt time.Time
 }
 
-// TODO test
-func UserAuthMiddleware(users map[string]UserInfo) func(http.Handler) http.Handler {
+// Expired reports whether the credential of the user is not accepted anymore
+func (u UserInfo) Expired() bool {
+	return !u.ExpiresAt.IsZero() && time.Now().After(u.ExpiresAt)
+}
+
+// SpaceOptions are server side defaults of a space
+type SpaceOptions struct {
+	Backup           conflict.BackupMode
+	RenameOnConflict bool
+	Symlinks         confine.SymlinkPolicy
+}
+
+// Limits of the server, zero values mean no limit
+type Limits struct {
+	// MaxUploadSize is in bytes
+	MaxUploadSize int64
+}
+
+type AuthOptions struct {
+	Signer *token.Signer
+	// AllowSimple accepts the GUID itself on every request, instead of only on login
+	AllowSimple bool
+	CertUsers   CertUsers
+	// Guard limits authentication failures, nil for no limits
+	Guard     *authlimit.Guard
+	Addresses UserAddresses
+}
+
+// UserAddresses are the address rules of users by ID, Default applies to users without their own
+type UserAddresses struct {
+	Users   map[string]access.AddressRules
+	Default access.AddressRules
+}
+
+// Check writes an error, logs and returns false if the user can not authenticate from the address of the request
+func (u UserAddresses) Check(w http.ResponseWriter, r *http.Request, user *UserInfo) bool {
+	rules, ok := u.Users[user.ID]
+	if !ok {
+		rules = u.Default
+	}
+
+	ip := RemoteIP(r)
+	if rules.Allows(ip) {
+		return true
+	}
+
+	Logger(r).Warn("auth rejected, address is not allowed", "user", user.Name, "address", ip.String())
+	WriteAPIErr(w, http.StatusForbidden, "user is not allowed to authenticate from this address")
+	return false
+}
+
+// RemoteIP is the IP address of the client of the request, or nil
+func RemoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// CertUsers are users authenticated by TLS client certificates
+type CertUsers struct {
+	// ClientCAs verify certificates of users identified by subject, users identified by fingerprint need no CA
+	ClientCAs    *x509.CertPool
+	Fingerprints map[string]UserInfo
+	Subjects     map[string]UserInfo
+}
+
+// User returns the user of the client certificate of the request, or nil
+func (c CertUsers) User(r *http.Request) *UserInfo {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := r.TLS.PeerCertificates[0]
+
+	if user, ok := c.Fingerprints[CertFingerprint(cert)]; ok {
+		if user.Expired() {
+			return nil
+		}
+		return &user
+	}
+
+	user, ok := c.Subjects[cert.Subject.String()]
+	if !ok || c.ClientCAs == nil || user.Expired() {
+		return nil
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, intermediate := range r.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(intermediate)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         c.ClientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil
+	}
+	return &user
+}
+
+// CertFingerprint is the lowercase hex SHA-256 of the certificate
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint makes fingerprints like 'AB:CD:...' comparable to CertFingerprint
+func NormalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+}
+
+// UserID derives a non-secret ID from the user GUID
+func UserID(GUID string) string {
+	sum := sha256.Sum256([]byte(GUID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// RejectToken refuses the session token of the request, telling the client a new login may help
+func RejectToken(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", token.RejectedChallenge)
+	WriteAPIErr(w, http.StatusUnauthorized, message)
+}
+
+func UserAuthMiddleware(users map[string]UserInfo, opts AuthOptions) func(http.Handler) http.Handler {
+	sessions := NewSessionUsers(users, opts.CertUsers)
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			headerParts := strings.Split(authHeader, " ")
-			if len(headerParts) != 2 || headerParts[0] != "simple" {
-				WriteAPIErr(w, http.StatusUnauthorized, "bad authentication")
+			scheme, credential, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+			if scheme == "" {
+				credential = PeerCertFingerprint(r)
+			}
+
+			if !CheckAuthLimit(w, r, opts.Guard, credential) {
 				return
 			}
 
-			user, ok := users[headerParts[1]]
-			if !ok {
-				WriteAPIErr(w, http.StatusUnauthorized, "bad authentication")
+			var user *UserInfo
+			switch scheme {
+			case "bearer":
+				claims, err := opts.Signer.Verify(credential)
+				if err != nil {
+					// expired tokens are normal for clients which were idle, they login again
+					if errors.Is(err, token.ErrExpired) {
+						RejectToken(w, "bad authentication: "+err.Error())
+					} else {
+						w.Header().Set("WWW-Authenticate", token.RejectedChallenge)
+						RefuseAuth(w, r, opts.Guard, credential, "bad token", "bad authentication: "+err.Error())
+					}
+					return
+				}
+
+				// the users may have changed since login, removed users and credentials lose access right away
+				current, ok := sessions[SessionKey{User: claims.User, Credential: claims.Credential}]
+				if !ok || current.Expired() {
+					RejectToken(w, "bad authentication: user or credential is not valid anymore")
+					return
+				}
+
+				// levels are the current ones, but never more than the ones of the token
+				spaces := make(map[string]access.Level, len(claims.Spaces))
+				for space, levelStr := range claims.Spaces {
+					claimed, err := access.ParseLevel(levelStr)
+					if err != nil {
+						RejectToken(w, "bad authentication: "+err.Error())
+						return
+					}
+					if level, ok := current.Spaces[space]; ok {
+						if level > claimed {
+							level = claimed
+						}
+						spaces[space] = level
+					}
+				}
+				user = &UserInfo{ID: current.ID, Name: current.Name, Spaces: spaces, Credential: current.Credential, ExpiresAt: current.ExpiresAt}
+			case "simple":
+				if !opts.AllowSimple {
+					WriteAPIErr(w, http.StatusUnauthorized, "simple authentication is disabled, login for a token")
+					return
+				}
+
+				user = SimpleAuthUser(r, users)
+			case "":
+				user = opts.CertUsers.User(r)
+			}
+
+			if user == nil {
+				RefuseAuth(w, r, opts.Guard, credential, AuthFailureReason(scheme, credential), "bad authentication")
+				return
+			}
+			opts.Guard.Succeed(credential)
+
+			if !opts.Addresses.Check(w, r, user) {
 				return
 			}
 
-			ctx := context.WithValue(r.Context(), UserContextKey, &user)
+			ctx := context.WithValue(r.Context(), UserContextKey, user)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-func FillTree(base string, tree map[string]*pb.TreeItem) error {
-	for key, item := range tree {
-		if !item.IsDir {
-			continue
+// CheckAuthLimit writes an error and returns false if the address or the credential of the request is locked out.
+// It should be called before the credential is looked up, so a locked out address can not find valid ones.
+func CheckAuthLimit(w http.ResponseWriter, r *http.Request, guard *authlimit.Guard, credential string) bool {
+	locked := guard.AddressLocked(r)
+	if credentialLocked := guard.CredentialLocked(credential); credentialLocked > locked {
+		locked = credentialLocked
+	}
+	return checkLockout(w, locked)
+}
+
+// RefuseAuth records the failed authentication and writes message as unauthorized
+func RefuseAuth(w http.ResponseWriter, r *http.Request, guard *authlimit.Guard, credential, reason, message string) {
+	AuthFailed(r, guard, credential, reason)
+	WriteAPIErr(w, http.StatusUnauthorized, message)
+}
+
+func checkLockout(w http.ResponseWriter, locked time.Duration) bool {
+	if locked == 0 {
+		return true
+	}
+
+	seconds := int64(math.Ceil(locked.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	WriteAPIErr(w, http.StatusTooManyRequests, fmt.Sprintf("too many failed authentications, try again in %d seconds", seconds))
+	return false
+}
+
+// AuthFailureReason describes a failed authentication for logs, without the credential itself
+func AuthFailureReason(scheme, credential string) string {
+	switch {
+	case scheme == "simple":
+		return "unknown GUID"
+	case scheme == "" && credential != "":
+		return "unknown client certificate " + credential
+	case scheme == "":
+		return "no credentials"
+	}
+	return fmt.Sprintf("unknown authorization scheme '%s'", scheme)
+}
+
+// PeerCertFingerprint is the fingerprint of the client certificate of the request, or empty
+func PeerCertFingerprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	return CertFingerprint(r.TLS.PeerCertificates[0])
+}
+
+// SessionKey identifies the credential a session token was issued for
+type SessionKey struct {
+	User       string
+	Credential string
+}
+
+// SessionUsers are the users of session tokens, by their ID and credential label
+type SessionUsers map[SessionKey]UserInfo
+
+// NewSessionUsers indexes the users identified by GUID and by certificate, so tokens are only accepted while the
+// credential they were issued for is still configured
+func NewSessionUsers(users map[string]UserInfo, certUsers CertUsers) SessionUsers {
+	sessions := SessionUsers{}
+	for _, userMap := range []map[string]UserInfo{users, certUsers.Fingerprints, certUsers.Subjects} {
+		for _, user := range userMap {
+			sessions[SessionKey{User: user.ID, Credential: user.Credential}] = user
 		}
+	}
+	return sessions
+}
+
+// SimpleAuthUser returns the user of the 'simple <GUID>' authorization header, or nil
+func SimpleAuthUser(r *http.Request, users map[string]UserInfo) *UserInfo {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "simple" {
+		return nil
+	}
+
+	user, ok := users[headerParts[1]]
+	if !ok || user.Expired() {
+		return nil
+	}
+	return &user
+}
 
-		curr := item.Path
-		children, err := os.ReadDir(curr)
-		if err != nil {
-			return err
+// auditRecord is filled by handlers with Audit and AuditBytes, and logged by AuditMiddleware
+type auditRecord struct {
+	operation string
+	path      string
+	bytes     int64
+}
+
+// AuditMiddleware logs the operation of every request whose handler called Audit, it should run after UserAuthMiddleware
+func AuditMiddleware(logger audit.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if logger == nil {
+			return next
 		}
 
-		for _, child := range children {
-			childName := child.Name()
-			tree[key].Children[childName] = &pb.TreeItem{
-				IsDir:    child.IsDir(),
-				Path:     path.Join(curr, childName),
-				Children: map[string]*pb.TreeItem{},
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			record := &auditRecord{}
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			start := time.Now()
+
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), AuditContextKey, record)))
+
+			if record.operation == "" {
+				return
 			}
-		}
 
-		if err = FillTree(curr, tree[key].Children); err != nil {
-			return err
-		}
+			event := audit.Event{
+				Time:       start,
+				RemoteAddr: r.RemoteAddr,
+				RequestID:  RequestID(r),
+				Operation:  record.operation,
+				Path:       record.path,
+				Bytes:      record.bytes,
+				Result:     audit.Result(sw.status),
+				Status:     sw.status,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if user, ok := r.Context().Value(UserContextKey).(*UserInfo); ok {
+				event.User, event.Credential = user.Name, user.Credential
+			}
+			logger.Log(event)
+		})
 	}
+}
 
-	return nil
+// Audit names the operation of the request and the space relative path it is on, for the audit log
+func Audit(r *http.Request, operation string, spacePath fmt.Stringer) {
+	if record, ok := r.Context().Value(AuditContextKey).(*auditRecord); ok {
+		record.operation, record.path = operation, spacePath.String()
+	}
 }
 
-func SpacePathToNormalPath(rawPath string, spaces map[string]string) (string, string, error) {
-	spaceName, filePath, err := SplitSpaceAndPath(rawPath)
+// AuditBytes records the bytes the request transferred, for the audit log
+func AuditBytes(r *http.Request, n int64) {
+	if record, ok := r.Context().Value(AuditContextKey).(*auditRecord); ok {
+		record.bytes += n
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = status, true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// CheckAccess writes an error and returns false if the user does not have level in the space
+func CheckAccess(w http.ResponseWriter, uInfo *UserInfo, spaceName string, level access.Level) bool {
+	userLevel := uInfo.Spaces[spaceName]
+	if userLevel == access.None {
+		WriteAPIErr(w, http.StatusUnauthorized, "unauthorized to access space")
+		return false
+	}
+
+	if userLevel < level {
+		WriteAPIErr(w, http.StatusForbidden, fmt.Sprintf("'%s' access to space is required, user has '%s'", level, userLevel))
+		return false
+	}
+
+	return true
+}
+
+// FillTree adds the children of dir to item, and theirs recursively. Symlinks are not walked into.
+func FillTree(dir SpacePath, item *pb.TreeItem) error {
+	children, err := os.ReadDir(dir.Disk)
 	if err != nil {
-		return "", "", fmt.Errorf("bad path: %v", err)
+		return err
 	}
 
-	spacePath, ok := spaces[spaceName]
-	if !ok {
-		return "", "", errors.New("space does not exist")
+	for _, child := range children {
+		if conflict.IsPartial(child.Name()) {
+			continue
+		}
+		childPath := dir.Join(child.Name())
+		childItem := &pb.TreeItem{
+			IsDir:    child.IsDir(),
+			Path:     childPath.String(),
+			Children: map[string]*pb.TreeItem{},
+		}
+		item.Children[child.Name()] = childItem
+
+		if childItem.IsDir {
+			if err = FillTree(childPath, childItem); err != nil {
+				return err
+			}
+		}
 	}
 
-	return path.Join(spacePath, filePath), spaceName, nil
+	return nil
 }
 
 // Splits space name and path in the space. Only works if the string is trimmed
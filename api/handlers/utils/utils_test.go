@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api/access"
+	"github.com/aigic8/gosyn/api/token"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserAuthMiddlewareBearer(t *testing.T) {
+	GUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	signer := token.NewSigner([]byte("with great power comes great responsibility"), time.Minute)
+	expiredSigner := token.NewSigner([]byte("with great power comes great responsibility"), -time.Minute)
+	otherSigner := token.NewSigner([]byte("with great power there must also come great responsibility"), time.Minute)
+	issueWith := func(signer *token.Signer, claims token.Claims) string {
+		tok, _, err := signer.Issue(claims)
+		if err != nil {
+			panic(err)
+		}
+		return tok
+	}
+	issue := func(claims token.Claims) string {
+		return issueWith(signer, claims)
+	}
+	peter := token.Claims{User: UserID(GUID), Name: "peter", Credential: "laptop", Spaces: map[string]string{"spiderman": "admin", "avengers": "write"}}
+	oldToken := token.Claims{User: UserID(GUID), Name: "peter", Spaces: map[string]string{"spiderman": "write"}}
+
+	type bearerTestCase struct {
+		Name   string
+		Users  map[string]UserInfo
+		Token  string
+		Status int
+		Spaces map[string]access.Level
+	}
+
+	testCases := []bearerTestCase{
+		{
+			Name:   "cappedByToken",
+			Users:  map[string]UserInfo{GUID: {ID: UserID(GUID), Name: "peter", GUID: GUID, Credential: "laptop", Spaces: map[string]access.Level{"spiderman": access.Admin, "avengers": access.Admin, "daily": access.Read}}},
+			Token:  issue(peter),
+			Status: http.StatusOK,
+			Spaces: map[string]access.Level{"spiderman": access.Admin, "avengers": access.Write},
+		},
+		{
+			Name:   "spaceRevoked",
+			Users:  map[string]UserInfo{GUID: {ID: UserID(GUID), Name: "peter", GUID: GUID, Credential: "laptop", Spaces: map[string]access.Level{"spiderman": access.Read}}},
+			Token:  issue(peter),
+			Status: http.StatusOK,
+			Spaces: map[string]access.Level{"spiderman": access.Read},
+		},
+		{
+			Name:   "userRemoved",
+			Users:  map[string]UserInfo{},
+			Token:  issue(peter),
+			Status: http.StatusUnauthorized,
+		},
+		{
+			Name:   "credentialRemoved",
+			Users:  map[string]UserInfo{GUID: {ID: UserID(GUID), Name: "peter", GUID: GUID, Credential: "phone", Spaces: map[string]access.Level{"spiderman": access.Admin}}},
+			Token:  issue(peter),
+			Status: http.StatusUnauthorized,
+		},
+		{
+			Name:   "userExpired",
+			Users:  map[string]UserInfo{GUID: {ID: UserID(GUID), Name: "peter", GUID: GUID, Credential: "laptop", Spaces: map[string]access.Level{"spiderman": access.Admin}, ExpiresAt: time.Now().Add(-time.Hour)}},
+			Token:  issue(peter),
+			Status: http.StatusUnauthorized,
+		},
+		{
+			Name:   "tokenWithoutCredential",
+			Users:  map[string]UserInfo{GUID: {ID: UserID(GUID), Name: "peter", GUID: GUID, Credential: "laptop", Spaces: map[string]access.Level{"spiderman": access.Admin}}},
+			Token:  issue(oldToken),
+			Status: http.StatusUnauthorized,
+		},
+		{
+			Name:   "tokenExpired",
+			Users:  map[string]UserInfo{GUID: {ID: UserID(GUID), Name: "peter", GUID: GUID, Credential: "laptop", Spaces: map[string]access.Level{"spiderman": access.Admin}}},
+			Token:  issueWith(expiredSigner, peter),
+			Status: http.StatusUnauthorized,
+		},
+		{
+			Name:   "tokenForged",
+			Users:  map[string]UserInfo{GUID: {ID: UserID(GUID), Name: "peter", GUID: GUID, Credential: "laptop", Spaces: map[string]access.Level{"spiderman": access.Admin}}},
+			Token:  issueWith(otherSigner, peter),
+			Status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var user *UserInfo
+			handler := UserAuthMiddleware(tc.Users, AuthOptions{Signer: signer})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user = r.Context().Value(UserContextKey).(*UserInfo)
+			}))
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "bearer "+tc.Token)
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, w.Result().StatusCode, tc.Status)
+			if tc.Status == http.StatusUnauthorized {
+				// clients login again for a new token on these
+				assert.Equal(t, w.Result().Header.Get("WWW-Authenticate"), token.RejectedChallenge)
+			}
+			if tc.Status == http.StatusOK {
+				assert.Equal(t, user.Spaces, tc.Spaces)
+				assert.Equal(t, user.Credential, "laptop")
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+// Package info describes what a gsyn build speaks, so clients and servers of different versions can work together
+package info
+
+// Version of gsyn, set when building with -ldflags "-X github.com/aigic8/gosyn/api/info.Version=v1.2.0"
+var Version = "dev"
+
+const (
+	// ProtocolVersion is increased on changes of the API which the other side has to know about
+	ProtocolVersion = 1
+	// MinProtocolVersion is the oldest protocol version of the other side this build works with.
+	// Servers from before /api/info speak protocol 0, clients log in first, which they can not do with most of them.
+	MinProtocolVersion = 1
+)
+
+// features a server can have, clients check them before using the options which need them
+const (
+	FeatureLogin            = "login"
+	FeatureCertAuth         = "cert-auth"
+	FeatureAccessLevels     = "access-levels"
+	FeatureBackup           = "backup"
+	FeatureRenameOnConflict = "rename-on-conflict"
+	FeatureHash             = "hash"
+	FeatureTree             = "tree"
+	FeatureTracing          = "tracing"
+)
+
+// Features are the features of this build
+var Features = []string{
+	FeatureLogin,
+	FeatureCertAuth,
+	FeatureAccessLevels,
+	FeatureBackup,
+	FeatureRenameOnConflict,
+	FeatureHash,
+	FeatureTree,
+	FeatureTracing,
+}
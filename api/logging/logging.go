@@ -0,0 +1,107 @@
+// Package logging builds the structured loggers of the server and the client
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// TraceLevel is below DebugLevel, for details like HTTP headers
+const TraceLevel = slog.DebugLevel - 4
+
+// RequestIDHeader carries the ID of a request in responses, clients can also pick the ID by sending it
+const RequestIDHeader = "X-Request-Id"
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Options of a logger, empty fields mean InfoLevel, text format and standard error
+type Options struct {
+	Level  slog.Leveler
+	Format string
+	// Output is 'stderr', 'stdout' or the path of a file logs are appended to
+	Output string
+}
+
+// ParseLevel parses 'trace', 'debug', 'info', 'warn' or 'error', empty is 'info'
+func ParseLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "trace":
+		return TraceLevel, nil
+	case "debug":
+		return slog.DebugLevel, nil
+	case "", "info":
+		return slog.InfoLevel, nil
+	case "warn":
+		return slog.WarnLevel, nil
+	case "error":
+		return slog.ErrorLevel, nil
+	}
+	return 0, fmt.Errorf("unknown log level '%s'", level)
+}
+
+// New makes a logger, the returned closer closes its output if it is a file
+func New(opts Options) (*slog.Logger, io.Closer, error) {
+	var out io.Writer
+	var closer io.Closer = nopCloser{}
+	switch opts.Output {
+	case "", "stderr":
+		out = os.Stderr
+	case "stdout":
+		out = os.Stdout
+	default:
+		if err := os.MkdirAll(filepath.Dir(opts.Output), 0750); err != nil {
+			return nil, nil, err
+		}
+		file, err := os.OpenFile(opts.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, nil, err
+		}
+		out, closer = file, file
+	}
+
+	handler, err := NewHandler(out, opts)
+	if err != nil {
+		closer.Close()
+		return nil, nil, err
+	}
+	return slog.New(handler), closer, nil
+}
+
+// NewHandler makes a handler writing to out with the level and format of opts, Output is ignored
+func NewHandler(out io.Writer, opts Options) (slog.Handler, error) {
+	level := opts.Level
+	if level == nil {
+		level = slog.InfoLevel
+	}
+	handlerOpts := slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+
+	switch opts.Format {
+	case "", FormatText:
+		return handlerOpts.NewTextHandler(out), nil
+	case FormatJSON:
+		return handlerOpts.NewJSONHandler(out), nil
+	}
+	return nil, fmt.Errorf("unknown log format '%s'", opts.Format)
+}
+
+// replaceLevel names TraceLevel, which slog would show as 'DEBUG-4'
+func replaceLevel(a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == TraceLevel {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
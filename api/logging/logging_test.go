@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("")
+	assert.Nil(t, err)
+	assert.Equal(t, level, slog.InfoLevel)
+
+	level, err = ParseLevel("WARN")
+	assert.Nil(t, err)
+	assert.Equal(t, level, slog.WarnLevel)
+
+	level, err = ParseLevel("trace")
+	assert.Nil(t, err)
+	assert.Equal(t, level, TraceLevel)
+
+	_, err = ParseLevel("loud")
+	assert.NotNil(t, err)
+}
+
+func TestNew(t *testing.T) {
+	logPath := path.Join(t.TempDir(), "logs/gsyn.log")
+
+	logger, closer, err := New(Options{Level: TraceLevel, Format: FormatJSON, Output: logPath})
+	assert.Nil(t, err)
+	logger.Log(TraceLevel, "headers", "accept", "*/*")
+	logger.Info("request", "status", 200)
+	assert.Nil(t, closer.Close())
+
+	data, err := os.ReadFile(logPath)
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"level":"TRACE","msg":"headers","accept":"*/*"`)
+	assert.Contains(t, string(data), `"level":"INFO","msg":"request","status":200`)
+
+	// lower levels are dropped
+	logger, closer, err = New(Options{Level: slog.WarnLevel, Output: logPath})
+	assert.Nil(t, err)
+	logger.Info("dropped")
+	assert.Nil(t, closer.Close())
+
+	data, err = os.ReadFile(logPath)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "dropped")
+
+	_, _, err = New(Options{Format: "xml"})
+	assert.NotNil(t, err)
+}
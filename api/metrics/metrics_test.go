@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWriteTo(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests.", "route", "status")
+	active := r.Gauge("active", "Active \\ transfers.")
+	durations := r.Histogram("duration_seconds", "Durations.", []float64{0.1, 1}, "route")
+
+	requests.Inc("/b", "200")
+	requests.Add(2, "/a", "200")
+	requests.Inc("/a\"\n", "404")
+	active.Inc()
+	active.Inc()
+	active.Dec()
+	durations.Observe(0.05, "/a")
+	durations.Observe(0.5, "/a")
+	durations.Observe(3, "/a")
+
+	out := &bytes.Buffer{}
+	n, err := r.WriteTo(out)
+	assert.Nil(t, err)
+	assert.Equal(t, n, int64(out.Len()))
+	assert.Equal(t, out.String(), `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{route="/a\"\n",status="404"} 1
+requests_total{route="/a",status="200"} 2
+requests_total{route="/b",status="200"} 1
+# HELP active Active \\ transfers.
+# TYPE active gauge
+active 1
+# HELP duration_seconds Durations.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 1
+duration_seconds_bucket{route="/a",le="1"} 2
+duration_seconds_bucket{route="/a",le="+Inf"} 3
+duration_seconds_sum{route="/a"} 3.55
+duration_seconds_count{route="/a"} 3
+`)
+
+	assert.Panics(t, func() { requests.Inc("/a") })
+}
+
+func TestRegistryHandler(t *testing.T) {
+	s := NewServer()
+	s.AuthFailures.Inc()
+
+	w := httptest.NewRecorder()
+	s.Registry.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, w.Header().Get("Content-Type"), ContentType)
+	assert.Contains(t, w.Body.String(), "gsyn_auth_failures_total 1\n")
+	assert.Contains(t, w.Body.String(), "gsyn_quic_connections 0\n")
+	assert.Contains(t, w.Body.String(), `gsyn_active_transfers{direction="upload"} 0`)
+}
@@ -0,0 +1,43 @@
+package metrics
+
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// DurationBuckets are in seconds, they go higher than usual since requests can be long file transfers
+var DurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Server are the metrics of a gsyn server
+type Server struct {
+	Registry *Registry
+
+	Requests        *CounterVec
+	RequestDuration *HistogramVec
+	// BytesReceived and BytesSent are file bytes by space and user name
+	BytesReceived   *CounterVec
+	BytesSent       *CounterVec
+	ActiveTransfers *GaugeVec
+	AuthFailures    *CounterVec
+	QUICConnections *GaugeVec
+	// QUICConnectionsTotal counts every connection, QUICConnections only the open ones
+	QUICConnectionsTotal *CounterVec
+}
+
+func NewServer() *Server {
+	r := NewRegistry()
+	s := &Server{
+		Registry:             r,
+		Requests:             r.Counter("gsyn_http_requests_total", "HTTP requests by route, method and status.", "route", "method", "status"),
+		RequestDuration:      r.Histogram("gsyn_http_request_duration_seconds", "Duration of HTTP requests by route, method and status.", DurationBuckets, "route", "method", "status"),
+		BytesReceived:        r.Counter("gsyn_file_bytes_received_total", "File bytes uploaded by space and user.", "space", "user"),
+		BytesSent:            r.Counter("gsyn_file_bytes_sent_total", "File bytes downloaded by space and user.", "space", "user"),
+		ActiveTransfers:      r.Gauge("gsyn_active_transfers", "File transfers in progress by direction.", "direction"),
+		AuthFailures:         r.Counter("gsyn_auth_failures_total", "Failed authentications."),
+		QUICConnections:      r.Gauge("gsyn_quic_connections", "Open QUIC connections."),
+		QUICConnectionsTotal: r.Counter("gsyn_quic_connections_total", "QUIC connections accepted."),
+	}
+	s.ActiveTransfers.Set(0, Download)
+	s.ActiveTransfers.Set(0, Upload)
+	return s
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/metrics"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// abortWait is how long handlers get to clean up, like removing partial uploads, once their connections are closed
+const abortWait = 5 * time.Second
+
+// Server serves the API over HTTP/3 and can be shut down without cutting transfers in flight
+type Server struct {
+	server  *http3.Server
+	drainer *utils.Drainer
+
+	mu       sync.Mutex
+	shutdown bool
+}
+
+// NewServer makes a server listening on addr. Certificates are taken from getCertificate on every handshake, so they can be rotated.
+// Client certificates are requested but not required, they are verified when the user is authenticated (see utils.CertUsers).
+// QUIC connections are counted in serverMetrics, unless it is nil.
+func NewServer(r http.Handler, addr string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), serverMetrics *metrics.Server) *Server {
+	quicConfig := &quic.Config{}
+	if serverMetrics != nil {
+		quicConfig.Tracer = connectionCounter{metrics: serverMetrics}
+	}
+
+	drainer := utils.NewDrainer()
+	return &Server{
+		drainer: drainer,
+		server: &http3.Server{
+			Handler:    drainer.Middleware(r),
+			Addr:       addr,
+			QuicConfig: quicConfig,
+			TLSConfig: &tls.Config{
+				GetCertificate: getCertificate,
+				ClientAuth:     tls.RequestClientCert,
+			},
+		},
+	}
+}
+
+// ListenAndServe serves requests until the server fails or is shut down, then nil is returned
+func (s *Server) ListenAndServe() error {
+	err := s.server.ListenAndServe()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return nil
+	}
+	return err
+}
+
+// Active is the number of requests in flight
+func (s *Server) Active() int {
+	return s.drainer.Active()
+}
+
+// Shutdown refuses new requests and waits for the ones in flight until ctx is done, then closes the connections.
+// Handlers of aborted requests are given a few seconds to clean up their partial files before it returns.
+// The error of ctx is returned if requests had to be aborted.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	s.mu.Unlock()
+
+	drainErr := s.drainer.Drain(ctx)
+	if err := s.server.Close(); err != nil {
+		return err
+	}
+	if drainErr != nil {
+		abortCtx, cancel := context.WithTimeout(context.Background(), abortWait)
+		defer cancel()
+		s.drainer.Drain(abortCtx)
+	}
+	return drainErr
+}
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// SwapHandler serves requests with the last handler it was given, so a router built from a reloaded configuration
+// can replace the old one without restarting. Requests in flight finish with the handler they started with.
+type SwapHandler struct {
+	current atomic.Pointer[http.Handler]
+}
+
+func NewSwapHandler(handler http.Handler) *SwapHandler {
+	s := &SwapHandler{}
+	s.Swap(handler)
+	return s
+}
+
+func (s *SwapHandler) Swap(handler http.Handler) {
+	s.current.Store(&handler)
+}
+
+func (s *SwapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.current.Load()).ServeHTTP(w, r)
+}
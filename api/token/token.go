@@ -0,0 +1,109 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// RejectedChallenge is the WWW-Authenticate header of responses refusing a session token, as RFC 6750 has it.
+// Clients login again only for these, a new token does not help with other 401 responses.
+const RejectedChallenge = `Bearer error="invalid_token"`
+
+var (
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token is expired")
+)
+
+// Claims are the data carried by a session token
+type Claims struct {
+	User string `json:"sub"`
+	Name string `json:"name,omitempty"`
+	// Credential is the label of the credential the user logged in with
+	Credential string `json:"cred,omitempty"`
+	// Spaces are the access levels of the user by space name
+	Spaces    map[string]string `json:"spaces"`
+	ExpiresAt int64             `json:"exp"`
+}
+
+// Signer issues and verifies HMAC-SHA256 signed session tokens.
+// A token is the base64 encoded JSON claims and the base64 encoded signature joined by a dot.
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewSigner(secret []byte, ttl time.Duration) *Signer {
+	return &Signer{secret: secret, ttl: ttl}
+}
+
+// TTL is the lifetime of issued tokens
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+// RandomSecret generates a secret for when none is configured, tokens are invalidated on restart then
+func RandomSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Issue returns a token for the claims and its expiry time.
+// ExpiresAt of claims, if set, is used when it is sooner than the TTL of the signer.
+func (s *Signer) Issue(claims Claims) (string, time.Time, error) {
+	expiresAt := time.Now().Add(s.ttl)
+	if claims.ExpiresAt != 0 && claims.ExpiresAt < expiresAt.Unix() {
+		expiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+	claims.ExpiresAt = expiresAt.Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	encPayload := base64.RawURLEncoding.EncodeToString(payload)
+	return encPayload + "." + base64.RawURLEncoding.EncodeToString(s.sign(encPayload)), expiresAt, nil
+}
+
+func (s *Signer) Verify(token string) (*Claims, error) {
+	encPayload, encSig, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalid
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil || !hmac.Equal(sig, s.sign(encPayload)) {
+		return nil, ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var claims Claims
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalid
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+
+	return &claims, nil
+}
+
+func (s *Signer) sign(encPayload string) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encPayload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,44 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner([]byte("breathe, breathe in the air"), time.Minute)
+	otherSigner := NewSigner([]byte("don't be afraid to care"), time.Minute)
+	expiredSigner := NewSigner([]byte("breathe, breathe in the air"), -time.Minute)
+
+	token, expiresAt, err := signer.Issue(Claims{User: "roger", Name: "Roger", Spaces: map[string]string{"music": "read", "lyrics": "write"}})
+	assert.Nil(t, err)
+	assert.True(t, expiresAt.After(time.Now()))
+
+	claims, err := signer.Verify(token)
+	assert.Nil(t, err)
+	assert.Equal(t, claims.User, "roger")
+	assert.Equal(t, claims.Name, "Roger")
+	assert.Equal(t, claims.Spaces, map[string]string{"music": "read", "lyrics": "write"})
+
+	_, err = otherSigner.Verify(token)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = signer.Verify(token[:len(token)-2])
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = signer.Verify("not-a-token")
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	expiredToken, _, err := expiredSigner.Issue(Claims{User: "roger", Spaces: map[string]string{"music": "read"}})
+	assert.Nil(t, err)
+	_, err = signer.Verify(expiredToken)
+	assert.ErrorIs(t, err, ErrExpired)
+
+	// tokens do not outlive the credential they were issued for
+	credentialExpiresAt := time.Now().Add(10 * time.Second).Unix()
+	_, expiresAt, err = signer.Issue(Claims{User: "roger", ExpiresAt: credentialExpiresAt})
+	assert.Nil(t, err)
+	assert.Equal(t, expiresAt.Unix(), credentialExpiresAt)
+}
@@ -0,0 +1,140 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// DefaultOTLPEndpoint is where an OpenTelemetry collector takes traces over HTTP by default
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// NewOTLPExporter sends spans to the OpenTelemetry collector at endpoint over OTLP/HTTP. Endpoint is the
+// traces URL of the collector, DefaultOTLPEndpoint if empty. Spans are sent in the background, so a collector
+// which is down does not fail anything but the export.
+func NewOTLPExporter(endpoint string) (*otlptrace.Exporter, error) {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host), otlptracehttp.WithTimeout(10 * time.Second)}
+	if u.Path != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	switch u.Scheme {
+	case "http":
+		opts = append(opts, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("endpoint '%s' is not an http or https URL", endpoint)
+	}
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// WriterExporter writes spans as JSON lines, for reading traces without a collector
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewFileExporter appends spans as JSON lines to the file at path, making its directory if needed
+func NewFileExporter(path string) (*WriterExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{w: f}, nil
+}
+
+// JSONSpan is a line written by WriterExporter
+type JSONSpan struct {
+	Service      string         `json:"service"`
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Name         string         `json:"name"`
+	Kind         string         `json:"kind"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	DurationMS   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+func (e *WriterExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, span := range spans {
+		s := JSONSpan{
+			Service:    serviceName(span.Resource()),
+			TraceID:    span.SpanContext().TraceID().String(),
+			SpanID:     span.SpanContext().SpanID().String(),
+			Name:       span.Name(),
+			Kind:       span.SpanKind().String(),
+			Start:      span.StartTime(),
+			End:        span.EndTime(),
+			DurationMS: float64(span.EndTime().Sub(span.StartTime()).Microseconds()) / 1000,
+		}
+		if span.Parent().IsValid() {
+			s.ParentSpanID = span.Parent().SpanID().String()
+		}
+		if len(span.Attributes()) != 0 {
+			s.Attributes = make(map[string]any, len(span.Attributes()))
+			for _, attr := range span.Attributes() {
+				s.Attributes[string(attr.Key)] = attr.Value.AsInterface()
+			}
+		}
+		if span.Status().Code == codes.Error {
+			s.Error = span.Status().Description
+		}
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// Shutdown closes the underlying writer if it is a file, standard output and error are left open
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	f, ok := e.w.(*os.File)
+	if !ok || f == os.Stdout || f == os.Stderr {
+		return nil
+	}
+	return f.Close()
+}
+
+func serviceName(res *resource.Resource) string {
+	if value, ok := res.Set().Value(semconv.ServiceNameKey); ok {
+		return value.AsString()
+	}
+	return ""
+}
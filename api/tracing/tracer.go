@@ -0,0 +1,68 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slog"
+)
+
+func init() {
+	// errors of the SDK, like failed exports, go to the log of gsyn instead of the standard logger
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Error("tracing", err)
+	}))
+}
+
+// Tracer starts spans of a service and exports them in batches in the background. A nil Tracer records nothing.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// NewTracer makes a tracer of service exporting to exporter. Root spans are sampled, the others follow their parent.
+// Batches are the defaults of the SDK: at most 512 spans every 5 seconds, with 2048 spans waiting at most.
+func NewTracer(service string, exporter sdktrace.SpanExporter) *Tracer {
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service)))
+	if err != nil {
+		// only happens with conflicting schema URLs, the SDK default and ours are the same version
+		res = resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	return &Tracer{provider: provider, tracer: provider.Tracer(instrumentationName)}
+}
+
+// Start starts a span, as a child of the current span of ctx, or of the remote parent taken by Extract,
+// or as the root of a new trace
+func (t *Tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	ctx, span := t.tracer.Start(ctx, name, opts...)
+	return ctx, &Span{span: span}
+}
+
+// Flush exports the spans which ended so far
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.ForceFlush(context.Background())
+}
+
+// Close exports the remaining spans and shuts the exporter down. Spans ending later are dropped.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(context.Background())
+}
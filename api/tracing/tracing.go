@@ -0,0 +1,114 @@
+// Package tracing records spans of work with the OpenTelemetry SDK, propagates them between client and server
+// with W3C trace context headers, and exports them through OTLP or as JSON lines
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceparentHeader carries the span context between client and server, see https://www.w3.org/TR/trace-context/
+const TraceparentHeader = "traceparent"
+
+// instrumentationName is the scope of the spans of gsyn
+const instrumentationName = "github.com/aigic8/gosyn"
+
+var propagator = propagation.TraceContext{}
+
+// Span is a timed piece of work. All of its methods can be called on a nil Span, which records nothing.
+type Span struct {
+	span trace.Span
+}
+
+func (s *Span) SpanContext() trace.SpanContext {
+	if s == nil {
+		return trace.SpanContext{}
+	}
+	return s.span.SpanContext()
+}
+
+// SetName renames the span, like when the route of a request is known only after routing
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.span.SetName(name)
+}
+
+// SetAttr sets a string, bool, int, int64 or float64 attribute, other values are recorded with fmt.Sprint
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(toAttr(key, value))
+}
+
+// SetError marks the span as failed, a nil err does nothing
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End finishes the span and hands it to the exporter if it is sampled. Calls after the first do nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// SpanFromContext is the current span of ctx, or nil
+func SpanFromContext(ctx context.Context) *Span {
+	span := trace.SpanFromContext(ctx)
+	if !span.SpanContext().IsValid() || span.SpanContext().IsRemote() {
+		return nil
+	}
+	return &Span{span: span}
+}
+
+// Start starts a child of the current span of ctx, with the same tracer.
+// Without a current span nothing is recorded and the returned span is nil.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	ctx, span := parent.span.TracerProvider().Tracer(instrumentationName).Start(ctx, name, opts...)
+	return ctx, &Span{span: span}
+}
+
+// Inject sets TraceparentHeader to the current span of ctx, if there is one
+func Inject(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract returns ctx with the span context of TraceparentHeader as the remote parent of the next span
+// started by a Tracer. Malformed headers are ignored, like the spec asks.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+func toAttr(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
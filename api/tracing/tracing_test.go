@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracerStart(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewTracer("gsyn", exporter)
+	defer tracer.Close()
+
+	ctx, root := tracer.Start(context.Background(), "cp")
+	childCtx, child := Start(ctx, "plan")
+	child.SetAttr("sources", 2)
+	child.SetError(errors.New("no such space"))
+
+	header := http.Header{}
+	Inject(childCtx, header)
+	assert.Equal(t, header.Get(TraceparentHeader), "00-"+child.SpanContext().TraceID().String()+"-"+child.SpanContext().SpanID().String()+"-01")
+
+	// the server side of the request
+	_, server := tracer.Start(Extract(context.Background(), header), "GET /api/files/stat")
+	server.End()
+	child.End()
+	child.End()
+	root.End()
+	// the in memory exporter forgets its spans on shutdown
+	assert.Nil(t, tracer.Flush())
+
+	assert.Equal(t, len(exporter.GetSpans()), 3)
+	spans := map[string]tracetest.SpanStub{}
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+		assert.Equal(t, span.SpanContext.TraceID(), root.SpanContext().TraceID())
+	}
+	assert.False(t, spans["cp"].Parent.IsValid())
+	assert.Equal(t, spans["plan"].Parent.SpanID(), spans["cp"].SpanContext.SpanID())
+	assert.Equal(t, spans["plan"].Status.Code, codes.Error)
+	assert.Equal(t, spans["plan"].Status.Description, "no such space")
+	assert.Equal(t, spans["plan"].Attributes, []attribute.KeyValue{attribute.Int("sources", 2)})
+	assert.Equal(t, spans["GET /api/files/stat"].Parent.SpanID(), spans["plan"].SpanContext.SpanID())
+	assert.True(t, spans["GET /api/files/stat"].Parent.IsRemote())
+}
+
+func TestTracerNotSampled(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracer := NewTracer("gsyn-server", exporter)
+	defer tracer.Close()
+
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	ctx, span := tracer.Start(Extract(context.Background(), header), "GET /api/files")
+	diskCtx, disk := Start(ctx, "disk read")
+	assert.Equal(t, disk.SpanContext().TraceID().String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+
+	// the decision of the client is passed on
+	header = http.Header{}
+	Inject(diskCtx, header)
+	assert.Equal(t, header.Get(TraceparentHeader), "00-4bf92f3577b34da6a3ce929d0e0e4736-"+disk.SpanContext().SpanID().String()+"-00")
+	disk.End()
+	span.End()
+	assert.Nil(t, tracer.Flush())
+	assert.Equal(t, len(exporter.GetSpans()), 0)
+
+	// without a span, nothing is recorded
+	_, span = Start(context.Background(), "disk read")
+	assert.Nil(t, span)
+	span.SetAttr("bytes", 10)
+	span.End()
+
+	// malformed headers start new traces
+	header = http.Header{}
+	header.Set(TraceparentHeader, "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	assert.Nil(t, SpanFromContext(Extract(context.Background(), header)))
+}
+
+func TestFileExporter(t *testing.T) {
+	tracesPath := path.Join(t.TempDir(), "traces/gsyn.jsonl")
+	exporter, err := NewFileExporter(tracesPath)
+	assert.Nil(t, err)
+	tracer := NewTracer("gsyn", exporter)
+
+	ctx, root := tracer.Start(context.Background(), "cp")
+	_, child := Start(ctx, "client.GetStat", trace.WithSpanKind(trace.SpanKindClient))
+	child.SetAttr("path", "pink-floyd/time.txt")
+	child.End()
+	root.End()
+	assert.Nil(t, tracer.Close())
+
+	f, err := os.Open(tracesPath)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	spans := []JSONSpan{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var span JSONSpan
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &span))
+		spans = append(spans, span)
+	}
+	assert.Equal(t, len(spans), 2)
+	assert.Equal(t, spans[0].Name, "client.GetStat")
+	assert.Equal(t, spans[0].Kind, "client")
+	assert.Equal(t, spans[0].Service, "gsyn")
+	assert.Equal(t, spans[0].ParentSpanID, spans[1].SpanID)
+	assert.Equal(t, spans[0].Attributes, map[string]any{"path": "pink-floyd/time.txt"})
+	assert.Equal(t, spans[1].ParentSpanID, "")
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body []byte
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.URL.Path, "/otlp/v1/traces")
+		assert.Equal(t, r.Header.Get("Content-Type"), "application/x-protobuf")
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer collector.Close()
+
+	exporter, err := NewOTLPExporter(collector.URL + "/otlp/v1/traces")
+	assert.Nil(t, err)
+	tracer := NewTracer("gsyn-server", exporter)
+	ctx, span := tracer.Start(context.Background(), "GET /api/files", trace.WithSpanKind(trace.SpanKindServer))
+	span.SetAttr("http.status_code", 500)
+	span.SetError(errors.New("status 500"))
+	_, disk := Start(ctx, "disk read")
+	disk.SetAttr("path", "pink-floyd/time.txt")
+	disk.End()
+	span.End()
+	assert.Nil(t, tracer.Close())
+
+	var req coltracepb.ExportTraceServiceRequest
+	assert.Nil(t, proto.Unmarshal(body, &req))
+	assert.Equal(t, len(req.ResourceSpans), 1)
+	assert.Contains(t, req.ResourceSpans[0].Resource.Attributes, &commonpb.KeyValue{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "gsyn-server"}}})
+	assert.Equal(t, len(req.ResourceSpans[0].ScopeSpans), 1)
+	assert.Equal(t, req.ResourceSpans[0].ScopeSpans[0].Scope.Name, "github.com/aigic8/gosyn")
+
+	spans := map[string]*tracepb.Span{}
+	for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+	assert.Equal(t, len(spans), 2)
+	server := spans["GET /api/files"]
+	assert.Equal(t, server.Kind, tracepb.Span_SPAN_KIND_SERVER)
+	traceID := span.SpanContext().TraceID()
+	assert.Equal(t, server.TraceId, traceID[:])
+	assert.Equal(t, server.Status.Code, tracepb.Status_STATUS_CODE_ERROR)
+	assert.Equal(t, server.Status.Message, "status 500")
+	assert.Equal(t, server.Attributes[0].Value.GetIntValue(), int64(500))
+	assert.Equal(t, spans["disk read"].ParentSpanId, server.SpanId)
+
+	// only http and https collectors are supported
+	_, err = NewOTLPExporter("grpc://localhost:4317")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aigic8/gosyn/cmd/gsyn/config"
+)
+
+type (
+	certArgs struct {
+		Generate *certGenerateArgs `arg:"subcommand:generate" help:"generate a key and a self signed certificate"`
+	}
+
+	certGenerateArgs struct {
+		Hosts       []string `arg:"positional,required" help:"IP addresses and DNS names the certificate is valid for"`
+		KeyType     string   `arg:"--key-type" default:"ecdsa" help:"ecdsa or ed25519"`
+		Days        int      `arg:"--days" default:"3650" help:"days the certificate is valid for"`
+		Out         string   `arg:"-o,--out" default:"." help:"directory to write cert.pem, cert.der and key.pem to"`
+		PatchConfig bool     `arg:"--patch-config" help:"set certPath and privPath of the server configuration to the generated files"`
+		Config      string   `arg:"-c,--config" help:"configuration to patch, default configuration paths are searched if empty"`
+	}
+)
+
+func CertGenerate(genArgs *certGenerateArgs) {
+	var pub crypto.PublicKey
+	var priv crypto.Signer
+	switch genArgs.KeyType {
+	case "ecdsa":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			errOut("generating key: %s", err.Error())
+		}
+		pub, priv = &key.PublicKey, key
+	case "ed25519":
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			errOut("generating key: %s", err.Error())
+		}
+		pub, priv = pubKey, privKey
+	default:
+		errOut("unknown key type '%s', should be 'ecdsa' or 'ed25519'", genArgs.KeyType)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		errOut("generating serial number: %s", err.Error())
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: genArgs.Hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 0, genArgs.Days),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		// client auth too, so the same command generates client certificates
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range genArgs.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		errOut("creating certificate: %s", err.Error())
+	}
+
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		errOut("encoding private key: %s", err.Error())
+	}
+
+	if err = os.MkdirAll(genArgs.Out, 0755); err != nil {
+		errOut("creating output directory: %s", err.Error())
+	}
+	outDir, err := filepath.Abs(genArgs.Out)
+	if err != nil {
+		errOut(err.Error())
+	}
+
+	certPath := filepath.Join(outDir, "cert.pem")
+	certDERPath := filepath.Join(outDir, "cert.der")
+	privPath := filepath.Join(outDir, "key.pem")
+
+	// checked before writing anything, and O_EXCL, so an existing key is never replaced by accident
+	for _, outPath := range []string{certPath, certDERPath, privPath} {
+		if _, err := os.Stat(outPath); err == nil {
+			errOut("'%s' already exists", outPath)
+		}
+	}
+	if err = writeNewFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0644); err != nil {
+		errOut("writing certificate: %s", err.Error())
+	}
+	if err = writeNewFile(certDERPath, certDER, 0644); err != nil {
+		errOut("writing certificate: %s", err.Error())
+	}
+	if err = writeNewFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		errOut("writing private key: %s", err.Error())
+	}
+
+	sum := sha256.Sum256(certDER)
+	fmt.Printf("certificate (PEM, for servers): %s\n", certPath)
+	fmt.Printf("certificate (DER, for clients): %s\n", certDERPath)
+	fmt.Printf("private key:                    %s\n", privPath)
+	fmt.Printf("SHA-256 fingerprint:            %s\n", hex.EncodeToString(sum[:]))
+
+	if !genArgs.PatchConfig {
+		return
+	}
+
+	configPath := genArgs.Config
+	if configPath == "" {
+		configPaths, err := config.GetConfigPaths()
+		if err != nil {
+			errOut("getting configuration paths: %s", err.Error())
+		}
+		if configPath = config.FindFirstFile(configPaths); configPath == "" {
+			errOut("no configuration was found to patch")
+		}
+	}
+
+	if err = config.PatchServerPaths(configPath, certPath, privPath); err != nil {
+		errOut("patching configuration: %s", err.Error())
+	}
+	fmt.Printf("updated certPath and privPath in '%s'\n", configPath)
+}
+
+func writeNewFile(filePath string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	return err
+}
@@ -6,8 +6,10 @@ import (
 	"io"
 	"os"
 	"path"
+	"reflect"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/pelletier/go-toml/v2"
@@ -20,33 +22,135 @@ type (
 	}
 
 	ClientConfig struct {
-		Servers        map[string]ClientServerItem `toml:"servers" validate:"required"`
+		Servers        map[string]ClientServerItem `toml:"servers" validate:"required,dive"`
 		DefaultTimeout int64                       `toml:"defaultTimeout" validate:"gte=0"`
+		ConnectTimeout int64                       `toml:"connectTimeout" validate:"gte=0"`
+		HeaderTimeout  int64                       `toml:"headerTimeout" validate:"gte=0"`
+		StallTimeout   int64                       `toml:"stallTimeout" validate:"gte=0"`
 		DefaultWorkers int                         `toml:"defaultWorkers" validate:"gte=0"`
+		Log            LogConfig                   `toml:"log"`
+		Tracing        TracingConfig               `toml:"tracing"`
 	}
 
 	ClientServerItem struct {
-		GUID         string   `toml:"GUID" validate:"required"`
+		GUID         string   `toml:"GUID" validate:"required_without=ClientCert,omitempty,uuid4"`
 		Address      string   `toml:"address" validate:"required,url"`
 		Certificates []string `toml:"certificates"`
+		ClientCert   string   `toml:"clientCert" validate:"required_with=ClientKey"`
+		ClientKey    string   `toml:"clientKey" validate:"required_with=ClientCert"`
+		// SystemRoots trusts the system roots besides Certificates, they are always trusted without Certificates
+		SystemRoots bool `toml:"systemRoots"`
+		// TOFU trusts the server certificate on first use and pins it in known_servers, instead of Certificates
+		TOFU bool `toml:"tofu" validate:"excluded_with=Certificates"`
 	}
 
 	ServerConfig struct {
-		Spaces   map[string]string `toml:"spaces" validate:"required"`
-		Users    []ServerUser      `toml:"users"`
-		Address  string            `toml:"address" validate:"required"`
-		CertPath string            `toml:"certPath"  validate:"required"`
-		PrivPath string            `toml:"privPath" validate:"required"`
+		Spaces       map[string]string             `toml:"spaces" validate:"required"`
+		SpaceOptions map[string]ServerSpaceOptions `toml:"spaceOptions" validate:"dive"`
+		Users        []ServerUser                  `toml:"users" validate:"dive"`
+		Address      string                        `toml:"address" validate:"required"`
+		CertPath     string                        `toml:"certPath"  validate:"required"`
+		PrivPath     string                        `toml:"privPath" validate:"required"`
+		// ClientCAPath is a PEM file of CAs which verify client certificates of users identified by certSubject
+		ClientCAPath string `toml:"clientCAPath"`
+		// TokenSecret signs session tokens, a random one is used if empty
+		TokenSecret     string          `toml:"tokenSecret" validate:"omitempty,min=32"`
+		TokenTTL        int64           `toml:"tokenTTL" validate:"gte=0"`
+		AllowSimpleAuth bool            `toml:"allowSimpleAuth"`
+		AuthLimit       ServerAuthLimit `toml:"authLimit"`
+		// AllowFrom and DenyFrom are CIDRs or addresses users without their own lists can authenticate from
+		AllowFrom []string      `toml:"allowFrom" validate:"dive,cidr|ip"`
+		DenyFrom  []string      `toml:"denyFrom" validate:"dive,cidr|ip"`
+		Audit     ServerAudit   `toml:"audit"`
+		Log       LogConfig     `toml:"log"`
+		Metrics   ServerMetrics `toml:"metrics"`
+		Tracing   TracingConfig `toml:"tracing"`
+		// MaxUploadSize is in megabytes, zero means no limit
+		MaxUploadSize int64 `toml:"maxUploadSize" validate:"gte=0"`
+		// GracePeriod is how many seconds transfers in flight get to finish when the server is stopped
+		GracePeriod int64 `toml:"gracePeriod" validate:"gte=0"`
 	}
 
+	ServerMetrics struct {
+		Enabled bool `toml:"enabled"`
+		// Address of a plain HTTP listener serving /healthz, /readyz and, if enabled, /metrics without authentication,
+		// like '127.0.0.1:9464'. It is required with Enabled, since metrics are only served there.
+		Address string `toml:"address" validate:"required_if=Enabled true"`
+	}
+
+	LogConfig struct {
+		Level  string `toml:"level" validate:"omitempty,oneof=trace debug info warn error"`
+		Format string `toml:"format" validate:"omitempty,oneof=text json"`
+		// Output is 'stderr' (the default), 'stdout' or the path of a file logs are appended to
+		Output string `toml:"output"`
+	}
+
+	// TracingConfig exports spans, nothing is traced if Exporter is empty
+	TracingConfig struct {
+		Exporter string `toml:"exporter" validate:"omitempty,oneof=otlp stdout file"`
+		// Endpoint is the OTLP/HTTP traces URL of the collector, like 'http://localhost:4318/v1/traces'
+		Endpoint string `toml:"endpoint" validate:"omitempty,url"`
+		// Path is the file spans are appended to as JSON lines by the file exporter
+		Path string `toml:"path" validate:"required_if=Exporter file"`
+		// ServiceName defaults to 'gsyn' for the client and 'gsyn-server' for the server
+		ServiceName string `toml:"serviceName"`
+	}
+
+	ServerAudit struct {
+		// Path of the audit log, no audit log is written if empty
+		Path string `toml:"path"`
+		// MaxSize is the size in megabytes the audit log is rotated at
+		MaxSize int64 `toml:"maxSize" validate:"gte=0"`
+		// MaxBackups is the number of rotated audit logs kept
+		MaxBackups int `toml:"maxBackups" validate:"gte=0"`
+	}
+
+	// ServerAuthLimit overrides the defaults of limiting failed authentications, durations are in seconds
+	ServerAuthLimit struct {
+		Disabled              bool  `toml:"disabled"`
+		MaxAddressFailures    int   `toml:"maxAddressFailures" validate:"gte=0"`
+		MaxCredentialFailures int   `toml:"maxCredentialFailures" validate:"gte=0"`
+		Window                int64 `toml:"window" validate:"gte=0"`
+		Lockout               int64 `toml:"lockout" validate:"gte=0"`
+		MaxLockout            int64 `toml:"maxLockout" validate:"gte=0"`
+	}
+
+	ServerSpaceOptions struct {
+		Backup           string `toml:"backup" validate:"omitempty,oneof=simple numbered"`
+		RenameOnConflict bool   `toml:"renameOnConflict"`
+		// Symlinks is 'inside' to follow symlinks staying in the space (the default) or 'forbid' to follow none
+		Symlinks string `toml:"symlinks" validate:"omitempty,oneof=inside forbid"`
+	}
+
+	// ServerUser is identified by one of GUID, CertSubject or CertFingerprint, or by any of its credentials
 	ServerUser struct {
-		GUID   string   `toml:"GUID" validate:"required,uuid4"`
-		Spaces []string `toml:"spaces"`
+		// Name shows who did what in logs, it is required with credentials
+		Name            string `toml:"name"`
+		GUID            string `toml:"GUID" validate:"omitempty,uuid4"`
+		CertSubject     string `toml:"certSubject"`
+		CertFingerprint string `toml:"certFingerprint"`
+		// Credentials are all valid side by side, so a credential can be rotated before the old one is removed
+		Credentials []ServerCredential `toml:"credentials" validate:"dive"`
+		Spaces      []string           `toml:"spaces"`
+		// AllowFrom and DenyFrom are CIDRs or addresses the user can authenticate from, instead of the server wide lists
+		AllowFrom []string `toml:"allowFrom" validate:"dive,cidr|ip"`
+		DenyFrom  []string `toml:"denyFrom" validate:"dive,cidr|ip"`
+	}
+
+	// ServerCredential is one of GUID, CertSubject or CertFingerprint
+	ServerCredential struct {
+		// Label tells credentials of a user apart, like the device they are on
+		Label           string `toml:"label"`
+		GUID            string `toml:"GUID" validate:"omitempty,uuid4"`
+		CertSubject     string `toml:"certSubject"`
+		CertFingerprint string `toml:"certFingerprint"`
+		// ExpiresAt is a TOML date-time after which the credential is not accepted, never if not set
+		ExpiresAt time.Time `toml:"expiresAt"`
 	}
 )
 
 func LoadConfig(configPaths []string) (*Config, error) {
-	configPath := findFirstFile(configPaths)
+	configPath := FindFirstFile(configPaths)
 	if configPath == "" {
 		configPathsStr := strings.Join(configPaths, "\n")
 		return nil, fmt.Errorf("no configuration was found in: \n%s", configPathsStr)
@@ -68,13 +172,124 @@ func LoadConfig(configPaths []string) (*Config, error) {
 	}
 
 	validate := validator.New()
-	if err = validate.Struct(&config); err != nil {
-		return nil, err
+	// errors name fields like they are written in the file
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		return name
+	})
+
+	if invalid := newValidationError(validate.Struct(&config)); invalid != nil {
+		return nil, invalid
 	}
 
 	return &config, nil
 }
 
+// ValidationError lists the fields of a configuration which are not valid, like 'server.address is required'
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// newValidationError lists the problems of err, an error of validator.Struct, it is nil if err is nil.
+// Fields are named by their namespace, like 'server.users[0].credentials[1].GUID'.
+func newValidationError(err error) *ValidationError {
+	if err == nil {
+		return nil
+	}
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return &ValidationError{Problems: []string{err.Error()}}
+	}
+
+	e := &ValidationError{}
+	for _, fieldErr := range fieldErrs {
+		// the namespace starts with the name of the validated struct, like 'Config.server.address'
+		_, field, _ := strings.Cut(fieldErr.Namespace(), ".")
+		e.Problems = append(e.Problems, field+" "+describeTag(fieldErr))
+	}
+	return e
+}
+
+func describeTag(fieldErr validator.FieldError) string {
+	param := fieldErr.Param()
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "required_if":
+		field, value, _ := strings.Cut(param, " ")
+		return fmt.Sprintf("is required when %s is '%s'", tomlName(field), value)
+	case "required_with":
+		return "is required with " + tomlName(param)
+	case "required_without":
+		return "is required without " + tomlName(param)
+	case "excluded_with":
+		return "can not be used with " + tomlName(param)
+	case "uuid4":
+		return "must be a UUID v4"
+	case "url":
+		return "must be a URL"
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(param), ", ")
+	case "gte":
+		return "must be at least " + param
+	case "min":
+		return fmt.Sprintf("must be at least %s characters", param)
+	case "cidr|ip":
+		return "must be a CIDR or an IP address"
+	}
+	return fmt.Sprintf("fails '%s'", fieldErr.Tag())
+}
+
+// tomlName is the name of a field in validation params, like 'ClientCert', as it is written in the file
+func tomlName(field string) string {
+	if field == "" || field == "GUID" {
+		return field
+	}
+	return strings.ToLower(field[:1]) + field[1:]
+}
+
+// RestartRequired lists the settings which differ between old and new but are only read when the server starts,
+// like 'address'. Users, spaces, their options and limits are applied when the configuration is reloaded.
+func RestartRequired(old, new *ServerConfig) []string {
+	settings := []struct {
+		name     string
+		old, new any
+	}{
+		{"address", old.Address, new.Address},
+		{"certPath", old.CertPath, new.CertPath},
+		{"privPath", old.PrivPath, new.PrivPath},
+		{"tokenSecret", old.TokenSecret, new.TokenSecret},
+		{"tokenTTL", old.TokenTTL, new.TokenTTL},
+		{"authLimit", old.AuthLimit, new.AuthLimit},
+		{"audit", old.Audit, new.Audit},
+		{"log", old.Log, new.Log},
+		{"metrics", old.Metrics, new.Metrics},
+		{"tracing", old.Tracing, new.Tracing},
+		{"gracePeriod", old.GracePeriod, new.GracePeriod},
+	}
+
+	changed := []string{}
+	for _, setting := range settings {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
+
+// AllCredentials are the credentials of the user, GUID, CertFingerprint and CertSubject of the user itself are the first one
+func (u ServerUser) AllCredentials() []ServerCredential {
+	if u.GUID == "" && u.CertFingerprint == "" && u.CertSubject == "" {
+		return u.Credentials
+	}
+	own := ServerCredential{GUID: u.GUID, CertFingerprint: u.CertFingerprint, CertSubject: u.CertSubject}
+	return append([]ServerCredential{own}, u.Credentials...)
+}
+
 func GetConfigPaths() ([]string, error) {
 	OS := runtime.GOOS
 
@@ -93,7 +308,7 @@ func GetConfigPaths() ([]string, error) {
 	return nil, fmt.Errorf("unsupported os '%s'", OS)
 }
 
-func findFirstFile(paths []string) string {
+func FindFirstFile(paths []string) string {
 	for _, pathStr := range paths {
 		stat, err := os.Stat(pathStr)
 		if err != nil {
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfigCredentials(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.toml")
+	configData := `
+[server]
+address = ":8686"
+certPath = "/path/to/cert.pem"
+privPath = "/path/to/key.pem"
+
+[[server.users]]
+name = "nick"
+GUID = "6a480a86-eea5-481d-bbae-5c4417519320"
+spaces = ["music"]
+
+[[server.users.credentials]]
+label = "laptop"
+GUID = "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+expiresAt = 2030-01-02T03:04:05Z
+
+[[server.users.credentials]]
+label = "phone"
+certFingerprint = "3f:a2"
+
+[server.spaces]
+music = "/home/user/spaces/music"
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		panic(err)
+	}
+
+	config, err := LoadConfig([]string{configPath})
+	assert.Nil(t, err)
+
+	credentials := config.Server.Users[0].AllCredentials()
+	assert.Equal(t, len(credentials), 3)
+	assert.Equal(t, credentials[0].GUID, "6a480a86-eea5-481d-bbae-5c4417519320")
+	assert.Equal(t, credentials[1].Label, "laptop")
+	assert.True(t, credentials[1].ExpiresAt.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)))
+	assert.True(t, credentials[2].ExpiresAt.IsZero())
+
+	badConfigData := []byte(`
+[server]
+address = ":8686"
+certPath = "/path/to/cert.pem"
+privPath = "/path/to/key.pem"
+users = [{ name = "nick", credentials = [{ label = "laptop", GUID = "not-a-uuid" }], spaces = ["music"] }]
+
+[server.spaces]
+music = "/home/user/spaces/music"
+`)
+	if err := os.WriteFile(configPath, badConfigData, 0600); err != nil {
+		panic(err)
+	}
+
+	_, err = LoadConfig([]string{configPath})
+	assert.EqualError(t, err, "server.users[0].credentials[0].GUID must be a UUID v4")
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	configPath := path.Join(t.TempDir(), "config.toml")
+	configData := `
+[client.servers.home]
+address = "https://1.2.3.4:8686"
+GUID = "not-a-uuid"
+
+[client.servers.work]
+address = "not a url"
+
+[server]
+certPath = "/path/to/cert.pem"
+privPath = "/path/to/key.pem"
+maxUploadSize = -1
+users = [
+  { name = "nick", allowFrom = ["10.8.0.0/33"], denyFrom = ["10.8.3.4", "not-an-address"], spaces = ["music"] },
+  { name = "rick", credentials = [{ label = "laptop", GUID = "6a480a86-eea5-481d-bbae-5c4417519320" }, { label = "phone", GUID = "not-a-uuid" }], spaces = ["music"] },
+]
+
+[server.spaces]
+music = "/home/user/spaces/music"
+
+[server.tracing]
+exporter = "file"
+
+[server.metrics]
+enabled = true
+`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		panic(err)
+	}
+
+	_, err := LoadConfig([]string{configPath})
+	var invalid *ValidationError
+	assert.ErrorAs(t, err, &invalid)
+	assert.ElementsMatch(t, invalid.Problems, []string{
+		"client.servers[home].GUID must be a UUID v4",
+		"client.servers[work].GUID is required without clientCert",
+		"client.servers[work].address must be a URL",
+		"server.address is required",
+		"server.tracing.path is required when exporter is 'file'",
+		"server.metrics.address is required when enabled is 'true'",
+		"server.maxUploadSize must be at least 0",
+		"server.users[0].allowFrom[0] must be a CIDR or an IP address",
+		"server.users[0].denyFrom[1] must be a CIDR or an IP address",
+		"server.users[1].credentials[1].GUID must be a UUID v4",
+	})
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := &ServerConfig{
+		Address:  ":8686",
+		Spaces:   map[string]string{"music": "/home/user/spaces/music"},
+		Users:    []ServerUser{{Name: "nick", GUID: "6a480a86-eea5-481d-bbae-5c4417519320", Spaces: []string{"music"}}},
+		Metrics:  ServerMetrics{Enabled: true},
+		TokenTTL: 900,
+	}
+	new := &ServerConfig{
+		Address:       ":8687",
+		Spaces:        map[string]string{"music": "/home/user/spaces/music", "videos": "/home/user/spaces/videos"},
+		Users:         []ServerUser{{Name: "roger", GUID: "f3b1f1cb-d1e6-4700-8f96-c28182563729", Spaces: []string{"videos"}}},
+		Metrics:       ServerMetrics{Enabled: true, Address: "127.0.0.1:9464"},
+		TokenTTL:      900,
+		MaxUploadSize: 100,
+	}
+
+	assert.Equal(t, RestartRequired(old, new), []string{"address", "metrics"})
+	assert.Equal(t, RestartRequired(old, old), []string{})
+}
+
+func TestRedactAndMarshal(t *testing.T) {
+	conf := &Config{
+		Client: &ClientConfig{Servers: map[string]ClientServerItem{
+			"home": {Address: "https://1.2.3.4:8686", GUID: "6a480a86-eea5-481d-bbae-5c4417519320"},
+		}},
+		Server: &ServerConfig{
+			Address:     ":8686",
+			TokenSecret: "a-random-string-of-at-least-32-characters",
+			Spaces:      map[string]string{"music": "/home/user/spaces/music"},
+			Users: []ServerUser{{
+				Name: "nick",
+				Credentials: []ServerCredential{
+					{Label: "laptop", GUID: "f3b1f1cb-d1e6-4700-8f96-c28182563729", ExpiresAt: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)},
+					{Label: "phone", CertFingerprint: "3f:a2"},
+				},
+				Spaces: []string{"music"},
+			}},
+		},
+	}
+
+	Redact(conf)
+	out, err := Marshal(conf)
+	assert.Nil(t, err)
+	text := string(out)
+	assert.NotContains(t, text, "6a480a86")
+	assert.NotContains(t, text, "f3b1f1cb")
+	assert.NotContains(t, text, "a-random-string")
+	assert.NotContains(t, text, "0001-01-01")
+	assert.Contains(t, text, "expiresAt = 2030-01-02T03:04:05Z")
+
+	// the user without a GUID of its own is not given a redacted one
+	var shown Config
+	assert.Nil(t, toml.Unmarshal(out, &shown))
+	assert.Equal(t, shown.Server.TokenSecret, Redacted)
+	assert.Equal(t, shown.Client.Servers["home"].GUID, Redacted)
+	assert.Equal(t, shown.Server.Users[0].GUID, "")
+	assert.Equal(t, shown.Server.Users[0].Credentials[0].GUID, Redacted)
+	assert.Equal(t, shown.Server.Users[0].Credentials[1].CertFingerprint, "3f:a2")
+}
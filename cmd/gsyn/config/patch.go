@@ -0,0 +1,83 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	serverTableRe = regexp.MustCompile(`^\s*\[server\]\s*(#.*)?$`)
+	tableRe       = regexp.MustCompile(`^\s*\[`)
+)
+
+// PatchServerPaths sets certPath and privPath of the server table. The file is edited as text, so comments
+// and formatting of the other lines are kept.
+func PatchServerPaths(configPath, certPath, privPath string) error {
+	stat, err := os.Stat(configPath)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	patched, err := patchServerTable(string(content), [][2]string{{"certPath", certPath}, {"privPath", privPath}})
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(configPath, []byte(patched), stat.Mode().Perm())
+}
+
+// patchServerTable replaces the string values of keys in the server table, adding the missing keys after the table header
+func patchServerTable(content string, values [][2]string) (string, error) {
+	lines := strings.Split(content, "\n")
+
+	start := -1
+	for i, line := range lines {
+		if serverTableRe.MatchString(line) {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return "", errors.New("no [server] table found")
+	}
+
+	end := len(lines)
+	for i := start + 1; i < len(lines); i++ {
+		if tableRe.MatchString(lines[i]) {
+			end = i
+			break
+		}
+	}
+
+	insertAt := start + 1
+	for _, kv := range values {
+		keyRe := regexp.MustCompile(`^(\s*)` + regexp.QuoteMeta(kv[0]) + `\s*=`)
+		newLine := fmt.Sprintf("%s = %s", kv[0], strconv.Quote(kv[1]))
+
+		found := false
+		for i := start + 1; i < end; i++ {
+			if match := keyRe.FindStringSubmatch(lines[i]); match != nil {
+				lines[i] = match[1] + newLine
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			lines = append(lines[:insertAt], append([]string{newLine}, lines[insertAt:]...)...)
+			insertAt++
+			end++
+		}
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type patchServerTableTestCase struct {
+	Name     string
+	Content  string
+	Expected string
+	Err      bool
+}
+
+func TestPatchServerTable(t *testing.T) {
+	values := [][2]string{{"certPath", "/etc/gsyn/cert.pem"}, {"privPath", "/etc/gsyn/key.pem"}}
+
+	testCases := []patchServerTableTestCase{
+		{
+			Name:     "replace",
+			Content:  "[client]\ncertPath = \"keep\"\n\n[server] # server part\naddress = \":8686\"\n  certPath = \"/old/cert.pem\"\nprivPath=\"/old/key.pem\"\n\n[server.spaces]\nmusic = \"/music\"\n",
+			Expected: "[client]\ncertPath = \"keep\"\n\n[server] # server part\naddress = \":8686\"\n  certPath = \"/etc/gsyn/cert.pem\"\nprivPath = \"/etc/gsyn/key.pem\"\n\n[server.spaces]\nmusic = \"/music\"\n",
+		},
+		{
+			Name:     "add",
+			Content:  "[server]\naddress = \":8686\"\n",
+			Expected: "[server]\ncertPath = \"/etc/gsyn/cert.pem\"\nprivPath = \"/etc/gsyn/key.pem\"\naddress = \":8686\"\n",
+		},
+		{
+			Name:    "noServerTable",
+			Content: "[client]\ndefaultWorkers = 10\n",
+			Err:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			patched, err := patchServerTable(tc.Content, values)
+			if tc.Err {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, patched, tc.Expected)
+		})
+	}
+}
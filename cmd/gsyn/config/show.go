@@ -0,0 +1,70 @@
+package config
+
+import (
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Redacted replaces secrets, the token secret and GUIDs, in configurations shown to people
+const Redacted = "<redacted>"
+
+// Redact replaces the secrets of conf with Redacted, leaving settings which are not set empty
+func Redact(conf *Config) {
+	redact := func(secret *string) {
+		if *secret != "" {
+			*secret = Redacted
+		}
+	}
+
+	if conf.Client != nil {
+		for name, server := range conf.Client.Servers {
+			redact(&server.GUID)
+			conf.Client.Servers[name] = server
+		}
+	}
+
+	if conf.Server != nil {
+		redact(&conf.Server.TokenSecret)
+		for i := range conf.Server.Users {
+			user := &conf.Server.Users[i]
+			redact(&user.GUID)
+			for j := range user.Credentials {
+				redact(&user.Credentials[j].GUID)
+			}
+		}
+	}
+}
+
+// Marshal encodes conf as TOML. Dates which are not set, like expiresAt of credentials without one, are left out.
+func Marshal(conf *Config) ([]byte, error) {
+	// times are structs without exported fields, which the omitempty of go-toml always leaves out,
+	// so they are dropped from a generic copy instead
+	encoded, err := toml.Marshal(conf)
+	if err != nil {
+		return nil, err
+	}
+	var generic map[string]any
+	if err = toml.Unmarshal(encoded, &generic); err != nil {
+		return nil, err
+	}
+	dropZeroTimes(generic)
+	return toml.Marshal(generic)
+}
+
+func dropZeroTimes(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if t, ok := child.(time.Time); ok && t.IsZero() {
+				delete(v, key)
+				continue
+			}
+			dropZeroTimes(child)
+		}
+	case []any:
+		for _, child := range v {
+			dropZeroTimes(child)
+		}
+	}
+}
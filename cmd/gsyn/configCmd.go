@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aigic8/gosyn/api/authlimit"
+	"github.com/aigic8/gosyn/api/handlers"
+	"github.com/aigic8/gosyn/api/tracing"
+	"github.com/aigic8/gosyn/cmd/gsyn/config"
+)
+
+type (
+	configArgs struct {
+		Check *configCheckArgs `arg:"subcommand:check" help:"validate the configuration, the files it refers to and the spaces"`
+		Show  *configShowArgs  `arg:"subcommand:show" help:"print the configuration with defaults filled in and secrets redacted"`
+	}
+
+	configCheckArgs struct {
+		Config string `arg:"-c,--config" help:"configuration to check, default configuration paths are searched if empty"`
+	}
+
+	configShowArgs struct {
+		Config string `arg:"-c,--config" help:"configuration to show, default configuration paths are searched if empty"`
+	}
+)
+
+// ConfigCheck reports every problem of the configuration found, instead of only the first one, and exits with 1 if
+// there are any. Besides its schema, certificates must parse and spaces must be directories the server can write to.
+func ConfigCheck(checkArgs *configCheckArgs) {
+	configPath := findConfigPath(checkArgs.Config)
+	conf, err := config.LoadConfig([]string{configPath})
+	if err != nil {
+		var invalid *config.ValidationError
+		if errors.As(err, &invalid) {
+			reportProblems(configPath, invalid.Problems)
+		}
+		errOut("loading configuration: %s", err.Error())
+	}
+
+	problems, warnings := checkConfig(conf)
+	for _, warning := range warnings {
+		warn(warning)
+	}
+	if len(problems) != 0 {
+		reportProblems(configPath, problems)
+	}
+	fmt.Printf("configuration '%s' is valid\n", configPath)
+}
+
+func checkConfig(conf *config.Config) (problems, warnings []string) {
+	if conf.Client == nil && conf.Server == nil {
+		return []string{"neither client nor server is configured"}, nil
+	}
+
+	if conf.Client != nil {
+		for _, name := range sortedKeys(conf.Client.Servers) {
+			server := conf.Client.Servers[name]
+			if _, err := makeTLSConfig(server.Certificates, server.SystemRoots, server.ClientCert, server.ClientKey); err != nil {
+				problems = append(problems, fmt.Sprintf("client.servers[%s]: %s", name, err))
+			}
+		}
+	}
+
+	if conf.Server != nil {
+		// undefined spaces, duplicate credentials and the client CA are checked while building the access
+		if sa, err := buildServerAccess(conf.Server); err != nil {
+			var invalid *config.ValidationError
+			if !errors.As(err, &invalid) {
+				invalid = &config.ValidationError{Problems: []string{err.Error()}}
+			}
+			for _, problem := range invalid.Problems {
+				problems = append(problems, "server: "+problem)
+			}
+		} else {
+			warnings = append(warnings, sa.Warnings...)
+		}
+
+		if _, err := tls.LoadX509KeyPair(conf.Server.CertPath, conf.Server.PrivPath); err != nil {
+			problems = append(problems, fmt.Sprintf("server.certPath and server.privPath: %s", err))
+		}
+
+		for _, name := range sortedKeys(conf.Server.Spaces) {
+			if err := handlers.CheckSpaceWritable(conf.Server.Spaces[name]); err != nil {
+				problems = append(problems, fmt.Sprintf("server.spaces.%s: '%s' %s", name, conf.Server.Spaces[name], err))
+			}
+		}
+	}
+
+	return problems, warnings
+}
+
+func reportProblems(configPath string, problems []string) {
+	for _, problem := range problems {
+		fmt.Fprint(os.Stderr, errPrepend)
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	fmt.Fprintf(os.Stderr, "configuration '%s' has %d problems\n", configPath, len(problems))
+	exit(1)
+}
+
+// ConfigShow prints the configuration the commands run with, values which are not set have their defaults
+func ConfigShow(showArgs *configShowArgs) {
+	configPath := findConfigPath(showArgs.Config)
+	conf, err := config.LoadConfig([]string{configPath})
+	if err != nil {
+		errOut("loading configuration: %s", err.Error())
+	}
+
+	applyDefaults(conf)
+	config.Redact(conf)
+	out, err := config.Marshal(conf)
+	if err != nil {
+		errOut("encoding configuration: %s", err.Error())
+	}
+	fmt.Printf("# %s, with defaults filled in and secrets redacted\n%s", configPath, out)
+}
+
+// applyDefaults sets the values which are not set in conf to the defaults commands use for them
+func applyDefaults(conf *config.Config) {
+	setDefault := func(value *int64, def int64) {
+		if *value == 0 {
+			*value = def
+		}
+	}
+	setTracingDefaults := func(tracingConf *config.TracingConfig, service string) {
+		if tracingConf.Exporter == "" {
+			return
+		}
+		if tracingConf.ServiceName == "" {
+			tracingConf.ServiceName = service
+		}
+		if tracingConf.Exporter == "otlp" && tracingConf.Endpoint == "" {
+			tracingConf.Endpoint = tracing.DefaultOTLPEndpoint
+		}
+	}
+
+	if client := conf.Client; client != nil {
+		setDefault(&client.DefaultTimeout, DEFAULT_TIMEOUT)
+		setDefault(&client.ConnectTimeout, client.DefaultTimeout)
+		setDefault(&client.HeaderTimeout, client.DefaultTimeout)
+		setDefault(&client.StallTimeout, client.DefaultTimeout)
+		if client.DefaultWorkers == 0 {
+			client.DefaultWorkers = DEFAULT_WORKERS
+		}
+		setLogDefaults(&client.Log)
+		setTracingDefaults(&client.Tracing, "gsyn")
+	}
+
+	if server := conf.Server; server != nil {
+		setDefault(&server.TokenTTL, DEFAULT_TOKEN_TTL)
+		setDefault(&server.GracePeriod, DEFAULT_GRACE_PERIOD)
+		if !server.AuthLimit.Disabled {
+			defaults := authlimit.DefaultOptions()
+			if server.AuthLimit.MaxAddressFailures == 0 {
+				server.AuthLimit.MaxAddressFailures = defaults.MaxAddressFailures
+			}
+			if server.AuthLimit.MaxCredentialFailures == 0 {
+				server.AuthLimit.MaxCredentialFailures = defaults.MaxCredentialFailures
+			}
+			setDefault(&server.AuthLimit.Window, int64(defaults.Window.Seconds()))
+			setDefault(&server.AuthLimit.Lockout, int64(defaults.Lockout.Seconds()))
+			setDefault(&server.AuthLimit.MaxLockout, int64(defaults.MaxLockout.Seconds()))
+		}
+		if server.Audit.Path != "" {
+			setDefault(&server.Audit.MaxSize, DEFAULT_AUDIT_MAX_SIZE)
+			if server.Audit.MaxBackups == 0 {
+				server.Audit.MaxBackups = DEFAULT_AUDIT_MAX_BACKUPS
+			}
+		}
+		setLogDefaults(&server.Log)
+		setTracingDefaults(&server.Tracing, "gsyn-server")
+	}
+}
+
+func setLogDefaults(logConf *config.LogConfig) {
+	if logConf.Level == "" {
+		logConf.Level = "info"
+	}
+	if logConf.Format == "" {
+		logConf.Format = "text"
+	}
+	if logConf.Output == "" {
+		logConf.Output = "stderr"
+	}
+}
+
+// findConfigPath is the configuration path of the flag, or the first one found in the default paths
+func findConfigPath(flag string) string {
+	if flag != "" {
+		return flag
+	}
+	configPaths, err := config.GetConfigPaths()
+	if err != nil {
+		errOut("getting configuration paths: %s", err.Error())
+	}
+	configPath := config.FindFirstFile(configPaths)
+	if configPath == "" {
+		errOut("no configuration was found in:\n%s", strings.Join(configPaths, "\n"))
+	}
+	return configPath
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
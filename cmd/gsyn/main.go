@@ -1,41 +1,81 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aigic8/gosyn/api"
+	"github.com/aigic8/gosyn/api/access"
+	"github.com/aigic8/gosyn/api/audit"
+	"github.com/aigic8/gosyn/api/authlimit"
+	"github.com/aigic8/gosyn/api/certs"
 	"github.com/aigic8/gosyn/api/client"
+	"github.com/aigic8/gosyn/api/conflict"
 	apiUtils "github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/info"
+	"github.com/aigic8/gosyn/api/logging"
+	"github.com/aigic8/gosyn/api/metrics"
+	"github.com/aigic8/gosyn/api/token"
+	"github.com/aigic8/gosyn/api/tracing"
 	"github.com/aigic8/gosyn/cmd/gsyn/config"
 	u "github.com/aigic8/gosyn/cmd/gsyn/utils"
 	"github.com/alexflint/go-arg"
 	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
-	"github.com/schollz/progressbar/v3"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
 )
 
 type (
 	args struct {
-		Cp    *cpArgs    `arg:"subcommand:cp"`
-		Serve *serveArgs `arg:"subcommand:serve"`
+		Cp     *cpArgs     `arg:"subcommand:cp"`
+		Serve  *serveArgs  `arg:"subcommand:serve"`
+		Cert   *certArgs   `arg:"subcommand:cert"`
+		Config *configArgs `arg:"subcommand:config"`
 	}
 
 	cpArgs struct {
-		Config  string   `arg:"-c,--config"`
-		Force   bool     `arg:"-f"`
-		Workers int      `arg:"-w,--workers"`
-		Paths   []string `arg:"positional"`
-		Timeout int64    `arg:"-t,--timeout"`
+		Config           string   `arg:"-c,--config"`
+		Force            bool     `arg:"-f"`
+		Update           bool     `arg:"-u,--update"`
+		Checksum         bool     `arg:"--checksum"`
+		NoClobber        bool     `arg:"-n,--no-clobber"`
+		Backup           string   `arg:"--backup"`
+		RenameOnConflict bool     `arg:"--rename-on-conflict"`
+		Quiet            bool     `arg:"-q,--quiet"`
+		Output           string   `arg:"-o,--output" default:"text"`
+		Workers          int      `arg:"-w,--workers"`
+		Paths            []string `arg:"positional"`
+		Timeout          int64    `arg:"-t,--timeout" help:"default for all the timeouts below, in milliseconds"`
+		ConnectTimeout   int64    `arg:"--connect-timeout" help:"max time for connecting and the handshake, in milliseconds"`
+		HeaderTimeout    int64    `arg:"--header-timeout" help:"max time to wait for response headers, in milliseconds"`
+		StallTimeout     int64    `arg:"--stall-timeout" help:"max time without any bytes transferred, in milliseconds"`
+		Verbose          bool     `arg:"-v,--verbose" help:"log HTTP requests and responses"`
+		VeryVerbose      bool     `arg:"--vv" help:"log HTTP requests and responses with their headers"`
+	}
+
+	copyOptions struct {
+		DestDirMode bool
+		Overwrite   u.OverwriteMode
+		Copy        u.CopyOptions
 	}
 
 	serveArgs struct {
@@ -43,13 +83,56 @@ type (
 	}
 )
 
+// Version is shown by --version
+func (args) Version() string {
+	return fmt.Sprintf("gsyn %s (protocol %d)", info.Version, info.ProtocolVersion)
+}
+
 const DEFAULT_TIMEOUT int64 = 5000
 const DEFAULT_WORKERS int = 10
+const DEFAULT_TOKEN_TTL int64 = 900
+const DEFAULT_AUDIT_MAX_SIZE int64 = 100
+const DEFAULT_AUDIT_MAX_BACKUPS int = 5
+const DEFAULT_GRACE_PERIOD int64 = 30
+const CERT_CHECK_INTERVAL = 10 * time.Second
+
+// optionalValueFlag can be used without a value, then it gets Default. Its Values can also be given as the next
+// argument, like '--backup numbered', any other next argument is not taken as its value.
+type optionalValueFlag struct {
+	Default string
+	Values  []string
+}
+
+var optionalValueFlags = map[string]optionalValueFlag{
+	"--backup": {Default: string(conflict.BackupSimple), Values: []string{string(conflict.BackupSimple), string(conflict.BackupNumbered)}},
+}
 
 func main() {
 	var args args
+	os.Args = expandOptionalValueFlags(os.Args)
 	arg.MustParse(&args)
-	go signalHandler()
+
+	// cert does not need a configuration, it may be creating the first one
+	if args.Cert != nil {
+		if args.Cert.Generate == nil {
+			errOut("missing cert command, available commands: generate")
+		}
+		CertGenerate(args.Cert.Generate)
+		return
+	}
+
+	// config reports problems of the configuration itself, instead of failing on the first one while loading it
+	if args.Config != nil {
+		switch {
+		case args.Config.Check != nil:
+			ConfigCheck(args.Config.Check)
+		case args.Config.Show != nil:
+			ConfigShow(args.Config.Show)
+		default:
+			errOut("missing config command, available commands: check, show")
+		}
+		return
+	}
 
 	var configPaths []string
 	var err error
@@ -78,22 +161,41 @@ func main() {
 				BaseAPIURL:   info.Address,
 				GUID:         info.GUID,
 				Certificates: info.Certificates,
+				ClientCert:   info.ClientCert,
+				ClientKey:    info.ClientKey,
+				TOFU:         info.TOFU,
+				SystemRoots:  info.SystemRoots,
 			}
 		}
 	}
 
 	if args.Cp != nil {
+		switch args.Cp.Output {
+		case "text":
+		case "json":
+			jsonOut = u.NewJSONReporter(os.Stdout)
+		default:
+			errOut("unknown output format '%s', should be 'text' or 'json'", args.Cp.Output)
+		}
+
 		if config.Client == nil {
 			errOut("no configuration found for client")
 		}
 
-		if args.Cp.Timeout == 0 {
-			if config.Client.DefaultTimeout != 0 {
-				args.Cp.Timeout = config.Client.DefaultTimeout
-			} else {
-				args.Cp.Timeout = DEFAULT_TIMEOUT
-			}
+		verbosity := 0
+		if args.Cp.VeryVerbose {
+			verbosity = 2
+		} else if args.Cp.Verbose {
+			verbosity = 1
 		}
+		logCloser := setupLogger(config.Client.Log, verbosity)
+		defer logCloser.Close()
+		tracer = setupTracer(config.Client.Tracing, "gsyn")
+		defer tracer.Close()
+
+		args.Cp.ConnectTimeout = pickTimeout(args.Cp.ConnectTimeout, args.Cp.Timeout, config.Client.ConnectTimeout, config.Client.DefaultTimeout)
+		args.Cp.HeaderTimeout = pickTimeout(args.Cp.HeaderTimeout, args.Cp.Timeout, config.Client.HeaderTimeout, config.Client.DefaultTimeout)
+		args.Cp.StallTimeout = pickTimeout(args.Cp.StallTimeout, args.Cp.Timeout, config.Client.StallTimeout, config.Client.DefaultTimeout)
 
 		if args.Cp.Workers == 0 {
 			if config.Client.DefaultWorkers != 0 {
@@ -110,50 +212,163 @@ func main() {
 			errOut("no configuration found for server")
 		}
 
-		for _, spacePath := range config.Server.Spaces {
-			if err = validateSpacePath(spacePath); err != nil {
-				warn("validating space '%s': %s", spacePath, err.Error())
+		logCloser := setupLogger(config.Server.Log, 0)
+		defer logCloser.Close()
+		tracer = setupTracer(config.Server.Tracing, "gsyn-server")
+		defer tracer.Close()
+
+		srvAccess, err := buildServerAccess(config.Server)
+		if err != nil {
+			errOut(err.Error())
+		}
+		for _, warning := range append(spaceWarnings(srvAccess.Spaces), srvAccess.Warnings...) {
+			warn(warning)
+		}
+
+		tokenSecret := []byte(config.Server.TokenSecret)
+		if len(tokenSecret) == 0 {
+			if tokenSecret, err = token.RandomSecret(); err != nil {
+				errOut("generating token secret: %s", err.Error())
 			}
 		}
+		tokenTTL := config.Server.TokenTTL
+		if tokenTTL == 0 {
+			tokenTTL = DEFAULT_TOKEN_TTL
+		}
+		authOptions := apiUtils.AuthOptions{
+			Signer:      token.NewSigner(tokenSecret, time.Duration(tokenTTL)*time.Second),
+			AllowSimple: srvAccess.AllowSimple,
+			CertUsers:   srvAccess.CertUsers,
+			Addresses:   srvAccess.Addresses,
+		}
+		if !config.Server.AuthLimit.Disabled {
+			authOptions.Guard = authlimit.NewGuard(authLimitOptions(config.Server.AuthLimit))
+		}
 
-		// FIXME bad dependency apiUtils, find a way to resolve
-		users := map[string]apiUtils.UserInfo{}
-		if config.Server.Users == nil || len(config.Server.Users) == 0 {
-			warn("starting server with no users!")
-		} else {
-			for _, user := range config.Server.Users {
-				spacesMap := map[string]bool{}
-				for _, space := range user.Spaces {
-					if _, ok := config.Server.Spaces[space]; !ok {
-						errOut("unknown space '%s'", space)
-					}
-					spacesMap[space] = true
-				}
-				users[user.GUID] = apiUtils.UserInfo{GUID: user.GUID, Spaces: spacesMap}
+		var auditLogger audit.Logger
+		if config.Server.Audit.Path != "" {
+			maxSize, maxBackups := config.Server.Audit.MaxSize, config.Server.Audit.MaxBackups
+			if maxSize == 0 {
+				maxSize = DEFAULT_AUDIT_MAX_SIZE
+			}
+			if maxBackups == 0 {
+				maxBackups = DEFAULT_AUDIT_MAX_BACKUPS
+			}
+			fileLogger, err := audit.NewFileLogger(config.Server.Audit.Path, maxSize*1024*1024, maxBackups)
+			if err != nil {
+				errOut("opening audit log: %s", err.Error())
 			}
+			defer fileLogger.Close()
+			auditLogger = fileLogger
 		}
 
-		r := api.Router(config.Server.Spaces, users)
-		err = api.Serve(r, config.Server.Address, config.Server.CertPath, config.Server.PrivPath)
+		var serverMetrics *metrics.Server
+		if config.Server.Metrics.Enabled {
+			serverMetrics = metrics.NewServer()
+		}
+		router := api.NewSwapHandler(api.Router(srvAccess.Spaces, srvAccess.SpaceOptions, srvAccess.Users, authOptions, srvAccess.Limits, auditLogger, serverMetrics, tracer))
+		adminRouter := api.NewSwapHandler(api.AdminRouter(srvAccess.Spaces, serverMetrics))
+		if adminAddress := config.Server.Metrics.Address; adminAddress != "" {
+			go func() {
+				if err := api.ServeAdmin(adminAddress, adminRouter); err != nil {
+					errOut("running admin listener: %s", err.Error())
+				}
+			}()
+		}
+
+		certReloader, err := certs.NewReloader(config.Server.CertPath, config.Server.PrivPath)
 		if err != nil {
+			errOut("loading certificate: %s", err.Error())
+		}
+		go certReloader.Watch(CERT_CHECK_INTERVAL, nil)
+
+		// the signer and the guard are kept, so sessions and lockouts survive reloads
+		go reloadOnHangup(certReloader, configPaths, config.Server, func(sa *serverAccess) {
+			opts := authOptions
+			opts.AllowSimple, opts.CertUsers, opts.Addresses = sa.AllowSimple, sa.CertUsers, sa.Addresses
+			router.Swap(api.Router(sa.Spaces, sa.SpaceOptions, sa.Users, opts, sa.Limits, auditLogger, serverMetrics, tracer))
+			adminRouter.Swap(api.AdminRouter(sa.Spaces, serverMetrics))
+		})
+
+		gracePeriod := config.Server.GracePeriod
+		if gracePeriod == 0 {
+			gracePeriod = DEFAULT_GRACE_PERIOD
+		}
+		server := api.NewServer(router, config.Server.Address, certReloader.GetCertificate, serverMetrics)
+		stopped := make(chan struct{})
+		go shutdownOnSignal(server, time.Duration(gracePeriod)*time.Second, stopped)
+
+		if err = server.ListenAndServe(); err != nil {
 			errOut("running server: %s", err.Error())
 		}
+		<-stopped
 	}
 
 }
 
-func signalHandler() {
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel)
-	for {
-		s := <-signalChannel
-		if s == os.Interrupt || s == os.Kill {
-			os.Exit(0)
+// expandOptionalValueFlags gives the flags of optionalValueFlags their value as '--flag=value', which is the only
+// way go-arg parses them. Arguments after '--' are positional and kept as they are.
+func expandOptionalValueFlags(rawArgs []string) []string {
+	res := make([]string, 0, len(rawArgs))
+	for i := 0; i < len(rawArgs); i++ {
+		rawArg := rawArgs[i]
+		if rawArg == "--" {
+			return append(res, rawArgs[i:]...)
+		}
+
+		if flag, ok := optionalValueFlags[rawArg]; ok {
+			value := flag.Default
+			if i+1 < len(rawArgs) && slices.Contains(flag.Values, rawArgs[i+1]) {
+				value = rawArgs[i+1]
+				i++
+			}
+			rawArg = rawArg + "=" + value
+		}
+		res = append(res, rawArg)
+	}
+	return res
+}
+
+// shutdownOnSignal stops the server on interrupt or SIGTERM, letting transfers in flight finish within gracePeriod.
+// A second signal stops it right away. stopped is closed once the server is shut down.
+func shutdownOnSignal(server *api.Server, gracePeriod time.Duration, stopped chan<- struct{}) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	s := <-signals
+	slog.Info("shutting down", "signal", s.String(), "active_requests", server.Active(), "grace_period", gracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
+	go func() {
+		select {
+		case <-signals:
+			slog.Warn("second signal, aborting requests in flight")
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("requests in flight were aborted", "error", err.Error())
+	} else {
+		slog.Info("server stopped")
 	}
+	close(stopped)
 }
 
 func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
+	// the first interrupt cancels the copies in flight, which remove their incomplete files, a second one exits right away
+	interruptCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-interruptCtx.Done()
+		stop()
+	}()
+
+	var ctx context.Context
+	ctx, cpSpan = tracer.Start(interruptCtx, "cp")
+	cpSpan.SetAttr("workers", cpArgs.Workers)
+
 	cwd, err := os.Getwd()
 	if err != nil {
 		errOut(err.Error())
@@ -164,7 +379,17 @@ func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
 		errOut("need at least a source and destination path")
 	}
 
-	certs := map[string]bool{}
+	overwrite, err := overwriteMode(cpArgs)
+	if err != nil {
+		errOut(err.Error())
+	}
+
+	backup, err := conflict.ParseBackupMode(cpArgs.Backup)
+	if err != nil {
+		errOut(err.Error())
+	}
+
+	usedServers := map[string]*u.ServerInfo{}
 	srcs := make([]*u.DynamicPath, 0, pathsLen-1)
 	for _, rawPath := range cpArgs.Paths[:pathsLen-1] {
 		dPath, err := u.NewDynamicPath(rawPath, cwd, servers)
@@ -174,9 +399,7 @@ func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
 		srcs = append(srcs, dPath)
 
 		if dPath.IsRemote {
-			for _, cert := range dPath.Server.Certificates {
-				certs[cert] = true
-			}
+			usedServers[dPath.Server.Name] = dPath.Server
 		}
 	}
 
@@ -185,27 +408,106 @@ func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
 		errOut("malformed path: %s", err.Error())
 	}
 	if dest.IsRemote {
-		for _, cert := range dest.Server.Certificates {
-			certs[cert] = true
+		usedServers[dest.Server.Name] = dest.Server
+	}
+
+	// each server gets its own transport, since servers can have different certificates and client certificates
+	transport := serverTransport{}
+	var knownServers *u.KnownServers
+	for _, name := range sortedKeys(usedServers) {
+		server := usedServers[name]
+		tlsConfig, err := makeTLSConfig(server.Certificates, server.SystemRoots, server.ClientCert, server.ClientKey)
+		if err != nil {
+			errOut("configuring TLS for server '%s': %s", server.Name, err.Error())
+		}
+
+		serverURL, err := url.Parse(server.BaseAPIURL)
+		if err != nil {
+			errOut("bad address for server '%s': %s", server.Name, err.Error())
+		}
+
+		if server.TOFU {
+			if len(server.Certificates) != 0 {
+				errOut("server '%s' can not use both tofu and certificates", server.Name)
+			}
+			if knownServers == nil {
+				if knownServers, err = loadKnownServers(); err != nil {
+					errOut("loading known servers: %s", err.Error())
+				}
+			}
+
+			// new servers are asked about before any request, one at a time, since requests have timeouts
+			if !knownServers.Known(serverURL.Host) {
+				rawCert, err := fetchServerCertificate(serverURL.Host, tlsConfig, time.Duration(cpArgs.ConnectTimeout)*time.Millisecond)
+				if err != nil {
+					errOut("connecting to server '%s': %s", server.Name, err.Error())
+				}
+				if err = knownServers.TrustOnFirstUse(serverURL.Host, rawCert); err != nil {
+					errOut(err.Error())
+				}
+			}
+
+			// the pinned fingerprint replaces the usual verification, so hostname and IP SANs are ignored
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyPeerCertificate = knownServers.VerifyFunc(serverURL.Host)
+		}
+
+		transport[serverURL.Host] = &http3.RoundTripper{
+			TLSClientConfig: tlsConfig,
+			QuicConfig:      &quic.Config{HandshakeIdleTimeout: time.Duration(cpArgs.ConnectTimeout) * time.Millisecond},
 		}
 	}
 
-	tlsConfig, err := makeTLSConfig(certs)
-	if err != nil {
-		errOut("configuring TLS: %s", err.Error())
+	c := &http.Client{Transport: client.LoggingTransport{Transport: transport, Logger: slog.Default()}}
+
+	gc := &client.GoSynClient{
+		C:             c,
+		HeaderTimeout: time.Duration(cpArgs.HeaderTimeout) * time.Millisecond,
+		StallTimeout:  time.Duration(cpArgs.StallTimeout) * time.Millisecond,
 	}
 
-	c := &http.Client{
-		Timeout:   time.Duration(cpArgs.Timeout) * time.Millisecond,
-		Transport: &http3.RoundTripper{TLSClientConfig: tlsConfig},
+	planCtx, planSpan := tracing.Start(ctx, "plan")
+	// servers are asked what they support, so options they do not have fail before any file is copied
+	for name, server := range usedServers {
+		serverInfo, err := gc.GetInfo(planCtx, server.BaseAPIURL, server.GUID)
+		if err != nil {
+			errOut("getting info of server '%s': %s", name, err.Error())
+		}
+		if err = client.CheckProtocol(serverInfo); err != nil {
+			errOut("server '%s': %s", name, err.Error())
+		}
+
+		features := []string{}
+		if overwrite == u.OverwriteChecksum {
+			features = append(features, info.FeatureHash)
+		}
+		if dest.IsRemote && dest.Server == server {
+			if backup != conflict.BackupNone {
+				features = append(features, info.FeatureBackup)
+			}
+			if cpArgs.RenameOnConflict {
+				features = append(features, info.FeatureRenameOnConflict)
+			}
+		}
+		if err = client.RequireFeatures(serverInfo, features...); err != nil {
+			errOut("server '%s': %s", name, err.Error())
+		}
 	}
 
-	gc := &client.GoSynClient{C: c}
+	// failing early instead of for every file, replacing files with write access is still checked per file by the server
+	for _, src := range srcs {
+		if err = src.CheckAccess(planCtx, gc, access.Read); err != nil {
+			errOut("checking access of '%s': %s", src.String(), err.Error())
+		}
+	}
+	if err = dest.CheckAccess(planCtx, gc, access.Create); err != nil {
+		errOut("checking access of '%s': %s", dest.String(), err.Error())
+	}
 
 	// destDirMode is when we destination MUST BE a directory to copy files to (when we have multiple sources or matches)
 	destDirMode := len(srcs) > 1
 	if destDirMode {
-		stat, err := dest.Stat(gc)
+		stat, err := dest.Stat(planCtx, gc)
 		if err != nil {
 			errOut(err.Error())
 		}
@@ -214,6 +516,7 @@ func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
 			errOut("path '%s' is not a dir (multiple sources)", dest.String())
 		}
 	}
+	planSpan.End()
 
 	matches := []*u.DynamicPath{}
 	srcsChann := make(chan *u.DynamicPath, cpArgs.Workers)
@@ -221,8 +524,9 @@ func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
 	wg := new(sync.WaitGroup)
 	wg.Add(cpArgs.Workers)
 
+	matchCtx, matchSpan := tracing.Start(ctx, "match")
 	for i := 0; i < cpArgs.Workers; i++ {
-		go getMatchesAsync(gc, srcsChann, matchesChann, wg)
+		go getMatchesAsync(matchCtx, gc, srcsChann, matchesChann, wg)
 	}
 
 	go func() {
@@ -241,6 +545,8 @@ func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
 	}
 
 	matchesLen := len(matches)
+	matchSpan.SetAttr("matches", matchesLen)
+	matchSpan.End()
 	if matchesLen == 0 {
 		errOut("no file matched the sources")
 		return
@@ -248,7 +554,7 @@ func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
 
 	if !destDirMode && matchesLen > 1 {
 		destDirMode = true
-		stat, err := dest.Stat(gc)
+		stat, err := dest.Stat(ctx, gc)
 		if err != nil {
 			errOut("getting '%s' info: %s", dest.String(), err.Error())
 		}
@@ -258,35 +564,206 @@ func CP(cpArgs *cpArgs, servers map[string]*u.ServerInfo) {
 		}
 	}
 
+	opts := copyOptions{
+		DestDirMode: destDirMode,
+		Overwrite:   overwrite,
+		Copy:        u.CopyOptions{Force: cpArgs.Force, Backup: backup, RenameOnConflict: cpArgs.RenameOnConflict},
+	}
+	var reporter u.Reporter
+	if jsonOut != nil {
+		reporter = jsonOut
+	} else {
+		reporter = u.NewTextReporter(os.Stderr, progressMode(cpArgs.Quiet), cpArgs.Workers)
+	}
+	reporter.Plan(srcs, matches, dest)
+
 	matchesOutChann := make(chan *u.DynamicPath, cpArgs.Workers)
 	cpWg := new(sync.WaitGroup)
 	cpWg.Add(cpArgs.Workers)
 
+	copyCtx, copySpan := tracing.Start(ctx, "copy")
 	for i := 0; i < cpArgs.Workers; i++ {
-		go copyAsync(gc, matchesOutChann, dest, destDirMode, cpArgs.Force, cpWg)
+		go copyAsync(copyCtx, gc, i, matchesOutChann, dest, opts, reporter, cpWg)
 	}
 
 	go func() {
 		defer close(matchesOutChann)
 		for _, match := range matches {
-			matchesOutChann <- match
+			select {
+			case matchesOutChann <- match:
+			case <-copyCtx.Done():
+				return
+			}
 		}
 	}()
 
 	cpWg.Wait()
+	copySpan.End()
+	summary := reporter.Finish()
+	cpSpan.SetAttr("copied", summary.Copied)
+	cpSpan.SetAttr("skipped", summary.Skipped)
+	cpSpan.SetAttr("failed", summary.Failed)
+	cpSpan.SetAttr("bytes", summary.Bytes)
+	if interruptCtx.Err() != nil {
+		warn("interrupted, %d of %d files were not copied", matchesLen-summary.Copied-summary.Skipped, matchesLen)
+		exit(130)
+	}
+	if summary.Failed != 0 {
+		exit(1)
+	}
+	cpSpan.End()
+}
+
+func progressMode(quiet bool) u.ProgressMode {
+	if quiet {
+		return u.ProgressQuiet
+	}
+	if isatty.IsTerminal(os.Stderr.Fd()) || isatty.IsCygwinTerminal(os.Stderr.Fd()) {
+		return u.ProgressTTY
+	}
+	return u.ProgressPlain
+}
+
+func overwriteMode(cpArgs *cpArgs) (u.OverwriteMode, error) {
+	mode := u.OverwriteDefault
+	modesCount := 0
+	if cpArgs.Update {
+		mode = u.OverwriteUpdate
+		modesCount++
+	}
+	if cpArgs.Checksum {
+		mode = u.OverwriteChecksum
+		modesCount++
+	}
+	if cpArgs.NoClobber {
+		mode = u.OverwriteNever
+		modesCount++
+	}
+
+	if modesCount > 1 {
+		return mode, errors.New("only one of --update, --checksum and --no-clobber can be used")
+	}
+	return mode, nil
+}
+
+// pickTimeout returns the first non-zero timeout, flags override the config and specific timeouts override the general one
+func pickTimeout(flag, generalFlag, conf, generalConf int64) int64 {
+	for _, timeout := range []int64{flag, generalFlag, conf, generalConf} {
+		if timeout != 0 {
+			return timeout
+		}
+	}
+	return DEFAULT_TIMEOUT
+}
+
+// setupLogger makes the default logger from conf, verbosity 1 or 2 lowers its level to debug or trace
+func setupLogger(conf config.LogConfig, verbosity int) io.Closer {
+	level, err := logging.ParseLevel(conf.Level)
+	if err != nil {
+		errOut("configuring logs: %s", err.Error())
+	}
+	if verbosity >= 2 && level > logging.TraceLevel {
+		level = logging.TraceLevel
+	} else if verbosity == 1 && level > slog.DebugLevel {
+		level = slog.DebugLevel
+	}
+
+	logger, closer, err := logging.New(logging.Options{Level: level, Format: conf.Format, Output: conf.Output})
+	if err != nil {
+		errOut("configuring logs: %s", err.Error())
+	}
+	slog.SetDefault(logger)
+	return closer
 }
 
+// setupTracer makes the tracer of conf, it is nil if no exporter is configured
+func setupTracer(conf config.TracingConfig, defaultService string) *tracing.Tracer {
+	var exporter sdktrace.SpanExporter
+	switch conf.Exporter {
+	case "":
+		return nil
+	case "otlp":
+		otlpExporter, err := tracing.NewOTLPExporter(conf.Endpoint)
+		if err != nil {
+			errOut("configuring tracing: %s", err.Error())
+		}
+		exporter = otlpExporter
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(conf.Path)
+		if err != nil {
+			errOut("configuring tracing: %s", err.Error())
+		}
+		exporter = fileExporter
+	}
+
+	service := conf.ServiceName
+	if service == "" {
+		service = defaultService
+	}
+	return tracing.NewTracer(service, exporter)
+}
+
+// authLimitOptions are the defaults of authlimit, overridden by the non-zero values of the config
+func authLimitOptions(conf config.ServerAuthLimit) authlimit.Options {
+	opts := authlimit.DefaultOptions()
+	if conf.MaxAddressFailures != 0 {
+		opts.MaxAddressFailures = conf.MaxAddressFailures
+	}
+	if conf.MaxCredentialFailures != 0 {
+		opts.MaxCredentialFailures = conf.MaxCredentialFailures
+	}
+	if conf.Window != 0 {
+		opts.Window = time.Duration(conf.Window) * time.Second
+	}
+	if conf.Lockout != 0 {
+		opts.Lockout = time.Duration(conf.Lockout) * time.Second
+	}
+	if conf.MaxLockout != 0 {
+		opts.MaxLockout = time.Duration(conf.MaxLockout) * time.Second
+	}
+	return opts
+}
+
+// tracer records spans when tracing is configured, it is nil otherwise
+var tracer *tracing.Tracer
+
+// cpSpan is the root span of cp, it is ended by exit so traces of failed copies are complete
+var cpSpan *tracing.Span
+
+// exit exports the spans recorded so far before exiting
+func exit(code int) {
+	if code != 0 {
+		cpSpan.SetError(fmt.Errorf("exit status %d", code))
+	}
+	cpSpan.End()
+	tracer.Close()
+	os.Exit(code)
+}
+
+// jsonOut is set when output format is JSON, errors and warnings are written as JSON events to it
+var jsonOut *u.JSONReporter
+
 var errPrepend = color.New(color.FgRed).Sprint(" ERROR ")
 
 func errOut(format string, a ...any) {
+	if jsonOut != nil {
+		jsonOut.Error(fmt.Errorf(format, a...))
+		exit(1)
+	}
 	fmt.Fprint(os.Stderr, errPrepend)
 	fmt.Fprintf(os.Stderr, format+"\n", a...)
-	os.Exit(1)
+	exit(1)
 }
 
 var warnPrepend = color.New(color.FgYellow).Sprint(" WARN ")
 
 func warn(format string, a ...any) {
+	if jsonOut != nil {
+		jsonOut.Warning(fmt.Sprintf(format, a...))
+		return
+	}
 	fmt.Fprint(os.Stderr, warnPrepend)
 	fmt.Fprintf(os.Stderr, format+"\n", a...)
 }
@@ -307,41 +784,103 @@ func validateSpacePath(spacePath string) error {
 	return nil
 }
 
-func makeTLSConfig(certificatePaths map[string]bool) (*tls.Config, error) {
-	if len(certificatePaths) == 0 {
-		return nil, nil
-	}
+// makeTLSConfig makes the TLS config of a server. Without certificates, the system trust store is used like any
+// HTTPS client does. With certificates, only they are trusted unless systemRoots is set.
+func makeTLSConfig(certificatePaths []string, systemRoots bool, clientCertPath, clientKeyPath string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS13}
 
-	certPool := x509.NewCertPool()
-	for certPath := range certificatePaths {
-		certFile, err := os.Open(certPath)
+	if len(certificatePaths) != 0 {
+		certPool, err := u.LoadCertPool(certificatePaths, systemRoots)
 		if err != nil {
 			return nil, err
 		}
-		defer certFile.Close()
+		tlsConfig.RootCAs = certPool
+	}
 
-		certBytes, err := io.ReadAll(certFile)
+	if clientCertPath != "" {
+		clientCert, err := tls.LoadX509KeyPair(clientCertPath, clientKeyPath)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("loading client certificate: %w", err)
 		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
 
-		cert, err := x509.ParseCertificate(certBytes)
-		if err != nil {
-			return nil, err
+	return tlsConfig, nil
+}
+
+func loadKnownServers() (*u.KnownServers, error) {
+	filePath, err := u.KnownServersPath()
+	if err != nil {
+		return nil, err
+	}
+	return u.LoadKnownServers(filePath, confirmServer)
+}
+
+// fetchServerCertificate returns the certificate of the server at host, through a handshake which sends no request
+func fetchServerCertificate(host string, tlsConfig *tls.Config, handshakeTimeout time.Duration) ([]byte, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+
+	var rawCert []byte
+	fetchConfig := tlsConfig.Clone()
+	fetchConfig.NextProtos = []string{http3.NextProtoH3}
+	fetchConfig.InsecureSkipVerify = true
+	fetchConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server sent no certificate")
 		}
+		rawCert = rawCerts[0]
+		return nil
+	}
+
+	conn, err := quic.DialAddr(host, fetchConfig, &quic.Config{HandshakeIdleTimeout: handshakeTimeout})
+	if err != nil {
+		return nil, err
+	}
+	conn.CloseWithError(0, "")
+	return rawCert, nil
+}
 
-		certPool.AddCert(cert)
+// confirmServer asks the user to trust a server seen for the first time, like SSH does
+func confirmServer(host, fingerprint string) bool {
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		warn("server '%s' is not known and can not ask for confirmation, run gsyn interactively once to trust it", host)
+		return false
 	}
 
-	return &tls.Config{
-		RootCAs: certPool,
-	}, nil
+	fmt.Fprintf(os.Stderr, "The authenticity of server '%s' can't be established.\n", host)
+	fmt.Fprintf(os.Stderr, "Certificate SHA-256 fingerprint is %s.\n", fingerprint)
+	fmt.Fprint(os.Stderr, "Are you sure you want to trust it (yes/no)? ")
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "yes" || answer == "y"
 }
 
-func getMatchesAsync(gc *client.GoSynClient, srcs <-chan *u.DynamicPath, out chan<- *u.DynamicPath, wg *sync.WaitGroup) {
+// serverTransport sends each request through the transport of its server host
+type serverTransport map[string]http.RoundTripper
+
+func (t serverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, ok := t[req.URL.Host]
+	if !ok {
+		return nil, fmt.Errorf("no transport for host '%s'", req.URL.Host)
+	}
+	return transport.RoundTrip(req)
+}
+
+func getMatchesAsync(ctx context.Context, gc *client.GoSynClient, srcs <-chan *u.DynamicPath, out chan<- *u.DynamicPath, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for src := range srcs {
-		matches, err := src.GetMatches(gc)
+		srcCtx, span := tracing.Start(ctx, "match source")
+		span.SetAttr("source", src.String())
+		matches, err := src.GetMatches(srcCtx, gc)
+		span.SetAttr("matches", len(matches))
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			warn("getting match for '%s': %s", src.String(), err.Error())
 		}
@@ -354,38 +893,96 @@ func getMatchesAsync(gc *client.GoSynClient, srcs <-chan *u.DynamicPath, out cha
 	}
 }
 
-func copyAsync(gc *client.GoSynClient, matches <-chan *u.DynamicPath, dest *u.DynamicPath, destDirMode bool, force bool, wg *sync.WaitGroup) {
+func copyAsync(ctx context.Context, gc *client.GoSynClient, worker int, matches <-chan *u.DynamicPath, dest *u.DynamicPath, opts copyOptions, reporter u.Reporter, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for match := range matches {
-		reader, size, err := match.Reader(gc)
+		if ctx.Err() != nil {
+			return
+		}
+		fileCtx, span := tracing.Start(ctx, "copy file")
+		span.SetAttr("source", match.String())
+		span.SetAttr("worker", worker)
+		copyFile(fileCtx, gc, worker, match, dest, opts, reporter)
+		span.End()
+	}
+}
+
+// copyFile copies a match to dest, errors and skips are reported to reporter and recorded on the span of ctx
+func copyFile(ctx context.Context, gc *client.GoSynClient, worker int, match *u.DynamicPath, dest *u.DynamicPath, opts copyOptions, reporter u.Reporter) {
+	span := tracing.SpanFromContext(ctx)
+	srcName := path.Base(match.Path)
+	matchDest := dest
+	if opts.DestDirMode {
+		matchDest = &u.DynamicPath{IsRemote: dest.IsRemote, Server: dest.Server, Path: path.Join(dest.Path, srcName)}
+	}
+
+	copyOpts := opts.Copy
+	if opts.Overwrite != u.OverwriteDefault {
+		target, targetStat, err := matchDest.CopyTarget(ctx, gc, srcName)
 		if err != nil {
-			errOut("reading '%s': %s", match.String(), err)
+			span.SetError(err)
+			reporter.FileError(match, matchDest, fmt.Errorf("getting '%s' info: %w", matchDest.String(), err))
+			return
 		}
 
-		bar := progressbar.NewOptions64(
-			size,
-			progressbar.OptionSetDescription(match.String()),
-			progressbar.OptionSetWriter(os.Stderr),
-			progressbar.OptionShowBytes(true),
-			progressbar.OptionSetWidth(20),
-			progressbar.OptionThrottle(65*time.Millisecond),
-			progressbar.OptionShowCount(),
-			progressbar.OptionOnCompletion(func() {
-				fmt.Fprint(os.Stderr, "\n")
-			}),
-			progressbar.OptionSpinnerType(14),
-			progressbar.OptionSetRenderBlankState(false),
-		)
-		r := io.TeeReader(reader, bar)
+		if targetStat != nil {
+			if targetStat.IsDir {
+				reporter.FileError(match, target, fmt.Errorf("path '%s' is a directory", target.String()))
+				return
+			}
+
+			replace, err := u.ShouldReplace(ctx, gc, match, target, targetStat, opts.Overwrite)
+			if err != nil {
+				span.SetError(err)
+				reporter.FileError(match, target, fmt.Errorf("comparing to '%s': %w", target.String(), err))
+				return
+			}
 
-		matchDest := dest
-		if destDirMode {
-			matchDest = &u.DynamicPath{IsRemote: dest.IsRemote, Server: dest.Server, Path: path.Join(dest.Path, path.Base(match.Path))}
+			if !replace {
+				span.SetAttr("skipped", true)
+				reporter.FileSkipped(match, target, skipReason(opts.Overwrite, target))
+				return
+			}
+			copyOpts.Force = true
 		}
+	}
+
+	reader, size, err := match.Reader(ctx, gc)
+	if err != nil {
+		span.SetError(err)
+		reporter.FileError(match, matchDest, fmt.Errorf("reading: %w", err))
+		return
+	}
 
-		if err = matchDest.Copy(gc, path.Base(match.Path), force, r); err != nil {
-			errOut("copying '%s' to '%s': %s", match.String(), matchDest.String(), err)
+	if matchDest.IsRemote {
+		// the info is cached, it was fetched while planning
+		serverInfo, err := gc.GetInfo(ctx, matchDest.Server.BaseAPIURL, matchDest.Server.GUID)
+		if limit := serverInfo.GetLimits().GetMaxUploadSize(); err == nil && limit > 0 && size > limit {
+			reader.Close()
+			reporter.FileError(match, matchDest, fmt.Errorf("file is larger than the upload limit of server '%s' (%d bytes)", matchDest.Server.Name, limit))
+			return
 		}
-		reader.Close()
 	}
+
+	fileReporter := reporter.FileStart(worker, match, matchDest, size)
+	written, err := matchDest.Copy(ctx, gc, srcName, copyOpts, io.TeeReader(reader, fileReporter))
+	reader.Close()
+	if err != nil {
+		span.SetError(err)
+		fileReporter.Fail(fmt.Errorf("copying to '%s': %w", matchDest.String(), err))
+		return
+	}
+	span.SetAttr("size", size)
+	span.SetAttr("destination", written.String())
+	fileReporter.Done(written)
+}
+
+func skipReason(mode u.OverwriteMode, target *u.DynamicPath) string {
+	switch mode {
+	case u.OverwriteUpdate:
+		return fmt.Sprintf("'%s' is up to date", target.String())
+	case u.OverwriteChecksum:
+		return fmt.Sprintf("'%s' has the same content", target.String())
+	}
+	return fmt.Sprintf("'%s' already exists", target.String())
 }
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type expandOptionalValueFlagsTestCase struct {
+	Name     string
+	Args     []string
+	Expected []string
+}
+
+func TestExpandOptionalValueFlags(t *testing.T) {
+	testCases := []expandOptionalValueFlagsTestCase{
+		{Name: "noValue", Args: []string{"gsyn", "cp", "--backup", "a.txt", "server:space"}, Expected: []string{"gsyn", "cp", "--backup=simple", "a.txt", "server:space"}},
+		{Name: "nextValue", Args: []string{"gsyn", "cp", "--backup", "numbered", "a.txt", "server:space"}, Expected: []string{"gsyn", "cp", "--backup=numbered", "a.txt", "server:space"}},
+		{Name: "lastFlag", Args: []string{"gsyn", "cp", "a.txt", "server:space", "--backup"}, Expected: []string{"gsyn", "cp", "a.txt", "server:space", "--backup=simple"}},
+		{Name: "equalsValue", Args: []string{"gsyn", "cp", "--backup=numbered", "a.txt", "server:space"}, Expected: []string{"gsyn", "cp", "--backup=numbered", "a.txt", "server:space"}},
+		{Name: "afterDoubleDash", Args: []string{"gsyn", "cp", "--backup", "--", "--backup", "server:space"}, Expected: []string{"gsyn", "cp", "--backup=simple", "--", "--backup", "server:space"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, expandOptionalValueFlags(tc.Args), tc.Expected)
+		})
+	}
+}
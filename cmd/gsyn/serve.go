@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/aigic8/gosyn/api/access"
+	"github.com/aigic8/gosyn/api/certs"
+	"github.com/aigic8/gosyn/api/confine"
+	"github.com/aigic8/gosyn/api/conflict"
+	apiUtils "github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/cmd/gsyn/config"
+	"golang.org/x/exp/slog"
+)
+
+// serverAccess is who can use which spaces of the server, built from its configuration on start and on every reload
+type serverAccess struct {
+	Spaces       map[string]string
+	SpaceOptions map[string]apiUtils.SpaceOptions
+	// Users are the users identified by GUID
+	Users     map[string]apiUtils.UserInfo
+	CertUsers apiUtils.CertUsers
+	Addresses apiUtils.UserAddresses
+	// AllowSimple accepts the GUID itself on every request, see apiUtils.AuthOptions
+	AllowSimple bool
+	Limits      apiUtils.Limits
+	// Warnings are problems which do not stop the server, like expired credentials
+	Warnings []string
+}
+
+// spaceWarnings are about spaces which are not directories, they do not stop the server since they can be mounted later
+func spaceWarnings(spaces map[string]string) []string {
+	warnings := []string{}
+	for _, spacePath := range spaces {
+		if err := validateSpacePath(spacePath); err != nil {
+			warnings = append(warnings, fmt.Sprintf("validating space '%s': %s", spacePath, err))
+		}
+	}
+	return warnings
+}
+
+// buildServerAccess builds the access of the server configuration. If it is not valid, the error is a
+// *config.ValidationError with every problem found, like undefined spaces and credentials used by two users.
+func buildServerAccess(conf *config.ServerConfig) (*serverAccess, error) {
+	var problems []string
+	problem := func(format string, a ...any) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	sa := &serverAccess{
+		Spaces:      conf.Spaces,
+		AllowSimple: conf.AllowSimpleAuth,
+		Limits:      apiUtils.Limits{MaxUploadSize: conf.MaxUploadSize * 1024 * 1024},
+	}
+	spaceOptions := map[string]apiUtils.SpaceOptions{}
+	for _, spaceName := range sortedKeys(conf.SpaceOptions) {
+		options := conf.SpaceOptions[spaceName]
+		if _, ok := conf.Spaces[spaceName]; !ok {
+			problem("options for unknown space '%s'", spaceName)
+			continue
+		}
+		symlinks, err := confine.ParseSymlinkPolicy(options.Symlinks)
+		if err != nil {
+			problem("options of space '%s': %s", spaceName, err)
+			continue
+		}
+		spaceOptions[spaceName] = apiUtils.SpaceOptions{
+			Backup:           conflict.BackupMode(options.Backup),
+			RenameOnConflict: options.RenameOnConflict,
+			Symlinks:         symlinks,
+		}
+	}
+
+	certUsers := apiUtils.CertUsers{Fingerprints: map[string]apiUtils.UserInfo{}, Subjects: map[string]apiUtils.UserInfo{}}
+	if conf.ClientCAPath != "" {
+		certUsers.ClientCAs = x509.NewCertPool()
+		if caBytes, err := os.ReadFile(conf.ClientCAPath); err != nil {
+			problem("reading client CA: %s", err)
+		} else if !certUsers.ClientCAs.AppendCertsFromPEM(caBytes) {
+			problem("no PEM certificates found in client CA '%s'", conf.ClientCAPath)
+		}
+	}
+
+	// FIXME bad dependency apiUtils, find a way to resolve
+	users := map[string]apiUtils.UserInfo{}
+	defaultAddresses, err := access.ParseAddressRules(conf.AllowFrom, conf.DenyFrom)
+	if err != nil {
+		problem("server allowFrom or denyFrom: %s", err)
+	}
+	addresses := apiUtils.UserAddresses{Users: map[string]access.AddressRules{}, Default: defaultAddresses}
+	if conf.Users == nil || len(conf.Users) == 0 {
+		sa.Warnings = append(sa.Warnings, "no users are configured")
+	} else {
+		names := map[string]bool{}
+		for _, user := range conf.Users {
+			spacesMap := map[string]access.Level{}
+			for _, rawSpace := range user.Spaces {
+				space, level, err := access.ParseSpaceAccess(rawSpace)
+				if err != nil {
+					problem("bad space '%s': %s", rawSpace, err)
+					continue
+				}
+				if _, ok := conf.Spaces[space]; !ok {
+					problem("unknown space '%s'", space)
+					continue
+				}
+				spacesMap[space] = level
+			}
+
+			credentials := user.AllCredentials()
+			if len(credentials) == 0 {
+				problem("user with spaces %v has no GUID, certSubject, certFingerprint or credentials", user.Spaces)
+				continue
+			}
+			if user.Name == "" && len(user.Credentials) != 0 {
+				problem("user with spaces %v has credentials but no name", user.Spaces)
+				continue
+			}
+
+			ID := apiUtils.UserID(user.Name)
+			if user.Name == "" {
+				first := credentials[0]
+				ID = apiUtils.UserID(first.GUID + apiUtils.NormalizeFingerprint(first.CertFingerprint) + first.CertSubject)
+			}
+			name := user.Name
+			if name == "" {
+				name = ID
+			}
+			if names[name] {
+				problem("more than one user is named '%s'", name)
+				continue
+			}
+			names[name] = true
+
+			if len(user.AllowFrom) != 0 || len(user.DenyFrom) != 0 {
+				if addresses.Users[ID], err = access.ParseAddressRules(user.AllowFrom, user.DenyFrom); err != nil {
+					problem("allowFrom or denyFrom of user '%s': %s", name, err)
+				}
+			}
+
+			for i, credential := range credentials {
+				label := credential.Label
+				if label == "" {
+					label = strconv.Itoa(i + 1)
+				}
+
+				fingerprint := apiUtils.NormalizeFingerprint(credential.CertFingerprint)
+				if credential.GUID+fingerprint+credential.CertSubject == "" {
+					problem("credential '%s' of user '%s' has no GUID, certSubject or certFingerprint", label, name)
+					continue
+				}
+				if !credential.ExpiresAt.IsZero() && credential.ExpiresAt.Before(time.Now()) {
+					sa.Warnings = append(sa.Warnings, fmt.Sprintf("credential '%s' of user '%s' has expired", label, name))
+				}
+
+				userInfo := apiUtils.UserInfo{
+					ID:         ID,
+					Name:       name,
+					GUID:       credential.GUID,
+					Spaces:     spacesMap,
+					Credential: label,
+					ExpiresAt:  credential.ExpiresAt,
+				}
+
+				if credential.GUID != "" {
+					if other, ok := users[credential.GUID]; ok {
+						problem("GUID of user '%s' is also used by '%s'", name, other.Name)
+					} else {
+						users[credential.GUID] = userInfo
+					}
+				}
+				if fingerprint != "" {
+					if other, ok := certUsers.Fingerprints[fingerprint]; ok {
+						problem("certFingerprint of user '%s' is also used by '%s'", name, other.Name)
+					} else {
+						certUsers.Fingerprints[fingerprint] = userInfo
+					}
+				}
+				if credential.CertSubject != "" {
+					if certUsers.ClientCAs == nil {
+						problem("user '%s' is identified by certificate subject, but no clientCAPath is set", name)
+					} else if other, ok := certUsers.Subjects[credential.CertSubject]; ok {
+						problem("certSubject of user '%s' is also used by '%s'", name, other.Name)
+					} else {
+						certUsers.Subjects[credential.CertSubject] = userInfo
+					}
+				}
+			}
+		}
+	}
+
+	if len(problems) != 0 {
+		return nil, &config.ValidationError{Problems: problems}
+	}
+	sa.SpaceOptions, sa.Users, sa.CertUsers, sa.Addresses = spaceOptions, users, certUsers, addresses
+	return sa, nil
+}
+
+// reloadOnHangup reloads the certificate and the configuration at configPaths on SIGHUP. Invalid configurations
+// are logged and the current one is kept.
+func reloadOnHangup(certReloader *certs.Reloader, configPaths []string, started *config.ServerConfig, apply func(*serverAccess)) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := certReloader.Reload(); err != nil {
+			slog.Error("reloading certificate failed, keeping the current one", err)
+		}
+		if err := reloadServer(configPaths, started, apply); err != nil {
+			slog.Error("reloading configuration failed, keeping the current one", err)
+		}
+	}
+}
+
+// reloadServer gives the access built from the configuration at configPaths to apply, apply is not called if the
+// configuration is invalid. Changes of settings which are only read on start (see config.RestartRequired) are logged.
+func reloadServer(configPaths []string, started *config.ServerConfig, apply func(*serverAccess)) error {
+	conf, err := config.LoadConfig(configPaths)
+	if err != nil {
+		return err
+	}
+	if conf.Server == nil {
+		return fmt.Errorf("no configuration found for server")
+	}
+	sa, err := buildServerAccess(conf.Server)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range append(spaceWarnings(sa.Spaces), sa.Warnings...) {
+		slog.Warn(warning)
+	}
+	for _, setting := range config.RestartRequired(started, conf.Server) {
+		slog.Warn("setting changed, restart the server to apply it", "setting", setting)
+	}
+	apply(sa)
+	slog.Info("configuration reloaded", "spaces", len(sa.Spaces), "users", len(conf.Server.Users))
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/aigic8/gosyn/api"
+	"github.com/aigic8/gosyn/api/access"
+	apiUtils "github.com/aigic8/gosyn/api/handlers/utils"
+	"github.com/aigic8/gosyn/api/pb"
+	"github.com/aigic8/gosyn/api/token"
+	"github.com/aigic8/gosyn/cmd/gsyn/config"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+type buildServerAccessTestCase struct {
+	Name  string
+	Users []config.ServerUser
+	Err   string
+}
+
+func TestBuildServerAccess(t *testing.T) {
+	GUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	otherGUID := "6a480a86-eea5-481d-bbae-5c4417519320"
+	testCases := []buildServerAccessTestCase{
+		{Name: "normal", Users: []config.ServerUser{{Name: "nick", Credentials: []config.ServerCredential{{Label: "laptop", GUID: GUID}}, Spaces: []string{"music:read"}}}},
+		{Name: "unknownSpace", Users: []config.ServerUser{{GUID: GUID, Spaces: []string{"movies"}}}, Err: "unknown space 'movies'"},
+		{Name: "badLevel", Users: []config.ServerUser{{GUID: GUID, Spaces: []string{"music:root"}}}, Err: "bad space 'music:root': unknown access level 'root', should be one of read, create, write, delete, admin"},
+		{Name: "noCredentials", Users: []config.ServerUser{{Name: "nick", Spaces: []string{"music"}}}, Err: "user with spaces [music] has no GUID, certSubject, certFingerprint or credentials"},
+		{Name: "credentialsWithoutName", Users: []config.ServerUser{{Credentials: []config.ServerCredential{{GUID: GUID}}, Spaces: []string{"music"}}}, Err: "user with spaces [music] has credentials but no name"},
+		{
+			Name:  "sharedGUID",
+			Users: []config.ServerUser{{Name: "nick", GUID: GUID, Spaces: []string{"music"}}, {Name: "fury", Credentials: []config.ServerCredential{{GUID: otherGUID}, {GUID: GUID}}, Spaces: []string{"music"}}},
+			Err:   "GUID of user 'fury' is also used by 'nick'",
+		},
+		{
+			Name:  "everyProblem",
+			Users: []config.ServerUser{{Name: "nick", GUID: GUID, Spaces: []string{"movies", "music"}}, {Name: "fury", GUID: GUID, Spaces: []string{"music:root"}}},
+			Err:   "unknown space 'movies'; bad space 'music:root': unknown access level 'root', should be one of read, create, write, delete, admin; GUID of user 'fury' is also used by 'nick'",
+		},
+		{Name: "certSubjectWithoutCA", Users: []config.ServerUser{{Name: "nick", CertSubject: "CN=nick", Spaces: []string{"music"}}}, Err: "user 'nick' is identified by certificate subject, but no clientCAPath is set"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			sa, err := buildServerAccess(&config.ServerConfig{Spaces: map[string]string{"music": "/home/user/spaces/music"}, Users: tc.Users})
+			if tc.Err != "" {
+				assert.EqualError(t, err, tc.Err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, sa.Users, map[string]apiUtils.UserInfo{
+				GUID: {ID: apiUtils.UserID("nick"), Name: "nick", GUID: GUID, Spaces: map[string]access.Level{"music": access.Read}, Credential: "laptop"},
+			})
+		})
+	}
+
+	sa, err := buildServerAccess(&config.ServerConfig{Spaces: map[string]string{"music": "/home/user/spaces/music"}})
+	assert.Nil(t, err)
+	assert.Equal(t, sa.Warnings, []string{"no users are configured"})
+}
+
+func TestReloadServer(t *testing.T) {
+	base := t.TempDir()
+	configPath := path.Join(base, "config.toml")
+	writeConfig := func(spaces, users string) {
+		configData := `
+[server]
+address = ":8686"
+certPath = "/path/to/cert.pem"
+privPath = "/path/to/key.pem"
+allowSimpleAuth = true
+` + users + `
+[server.spaces]
+` + spaces
+		if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+			panic(err)
+		}
+	}
+
+	nickGUID := "f3b1f1cb-d1e6-4700-8f96-c28182563729"
+	mariaGUID := "6a480a86-eea5-481d-bbae-5c4417519320"
+	writeConfig(`music = "`+base+`"`, `
+[[server.users]]
+name = "nick"
+GUID = "`+nickGUID+`"
+spaces = ["music"]
+`)
+	conf, err := config.LoadConfig([]string{configPath})
+	if err != nil {
+		panic(err)
+	}
+	sa, err := buildServerAccess(conf.Server)
+	if err != nil {
+		panic(err)
+	}
+
+	authOptions := apiUtils.AuthOptions{Signer: token.NewSigner([]byte("with great power comes great responsibility"), time.Minute), AllowSimple: true}
+	router := api.NewSwapHandler(api.Router(sa.Spaces, sa.SpaceOptions, sa.Users, authOptions, sa.Limits, nil, nil, nil))
+	apply := func(sa *serverAccess) {
+		router.Swap(api.Router(sa.Spaces, sa.SpaceOptions, sa.Users, authOptions, sa.Limits, nil, nil, nil))
+	}
+
+	getSpaces := func(GUID string) (int, []string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/spaces/all", nil)
+		r.Header.Set("Authorization", "simple "+GUID)
+		router.ServeHTTP(w, r)
+
+		res := w.Result()
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return res.StatusCode, nil
+		}
+		resBody, err := io.ReadAll(res.Body)
+		if err != nil {
+			panic(err)
+		}
+		var resData pb.SpaceGetAllResponse
+		if err := proto.Unmarshal(resBody, &resData); err != nil {
+			panic(err)
+		}
+		return res.StatusCode, resData.Spaces
+	}
+
+	status, spaces := getSpaces(nickGUID)
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, spaces, []string{"music"})
+
+	// invalid configurations keep the current router
+	writeConfig(`music = "`+base+`"`, `
+[[server.users]]
+name = "nick"
+GUID = "`+nickGUID+`"
+spaces = ["movies"]
+`)
+	assert.EqualError(t, reloadServer([]string{configPath}, conf.Server, apply), "unknown space 'movies'")
+	status, spaces = getSpaces(nickGUID)
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, spaces, []string{"music"})
+
+	writeConfig(`movies = "`+base+`"`, `
+[[server.users]]
+name = "maria"
+GUID = "`+mariaGUID+`"
+spaces = ["movies"]
+`)
+	assert.Nil(t, reloadServer([]string{configPath}, conf.Server, apply))
+	status, _ = getSpaces(nickGUID)
+	assert.Equal(t, status, http.StatusUnauthorized)
+	status, spaces = getSpaces(mariaGUID)
+	assert.Equal(t, status, http.StatusOK)
+	assert.Equal(t, spaces, []string{"movies"})
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// LoadCertPool makes a pool of the certificates in the files, starting from the system roots if systemRoots is set
+func LoadCertPool(certificatePaths []string, systemRoots bool) (*x509.CertPool, error) {
+	certPool := x509.NewCertPool()
+	if systemRoots {
+		var err error
+		if certPool, err = x509.SystemCertPool(); err != nil {
+			return nil, fmt.Errorf("loading system roots: %w", err)
+		}
+	}
+
+	for _, certPath := range certificatePaths {
+		certBytes, err := os.ReadFile(certPath)
+		if err != nil {
+			return nil, err
+		}
+
+		certs, err := ParseCertificates(certBytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing '%s': %w", certPath, err)
+		}
+
+		for _, cert := range certs {
+			certPool.AddCert(cert)
+		}
+	}
+
+	return certPool, nil
+}
+
+// ParseCertificates parses PEM files with any number of certificates (bundles and CA chains), or DER files
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		return x509.ParseCertificates(data)
+	}
+
+	certs := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
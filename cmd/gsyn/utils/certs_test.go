@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"encoding/pem"
+	"testing"
+
+	"github.com/aigic8/gosyn/api/handlers/handlerstest"
+	"github.com/stretchr/testify/assert"
+)
+
+type parseCertificatesTestCase struct {
+	Name  string
+	Data  []byte
+	Count int
+	Err   bool
+}
+
+func TestParseCertificates(t *testing.T) {
+	firstCert, _, err := handlerstest.MakeCert("time")
+	if err != nil {
+		panic(err)
+	}
+	secondCert, _, err := handlerstest.MakeCert("money")
+	if err != nil {
+		panic(err)
+	}
+	first, second := firstCert.Raw, secondCert.Raw
+	pemBundle := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: first}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: second})...)
+	pemWithKey := append(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not a key")}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: first})...)
+
+	testCases := []parseCertificatesTestCase{
+		{Name: "der", Data: first, Count: 1},
+		{Name: "derMultiple", Data: append(append([]byte{}, first...), second...), Count: 2},
+		{Name: "pemBundle", Data: pemBundle, Count: 2},
+		{Name: "pemSkipsOtherBlocks", Data: pemWithKey, Count: 1},
+		{Name: "pemNoCertificates", Data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("not a key")}), Err: true},
+		{Name: "garbage", Data: []byte("us and them"), Err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			certs, err := ParseCertificates(tc.Data)
+			if tc.Err {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			assert.Equal(t, len(certs), tc.Count)
+		})
+	}
+}
@@ -1,16 +1,23 @@
 package utils
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/aigic8/gosyn/api/access"
 	"github.com/aigic8/gosyn/api/client"
+	"github.com/aigic8/gosyn/api/confine"
+	"github.com/aigic8/gosyn/api/conflict"
 )
 
 type (
@@ -25,6 +32,10 @@ type (
 		BaseAPIURL   string
 		GUID         string
 		Certificates []string
+		ClientCert   string
+		ClientKey    string
+		TOFU         bool
+		SystemRoots  bool
 	}
 )
 
@@ -69,7 +80,7 @@ type StatInfo struct {
 	Size    int64
 }
 
-func (dPath *DynamicPath) Stat(gc *client.GoSynClient) (*StatInfo, error) {
+func (dPath *DynamicPath) Stat(ctx context.Context, gc *client.GoSynClient) (*StatInfo, error) {
 	if !dPath.IsRemote {
 		stat, err := os.Stat(dPath.Path)
 		if err != nil {
@@ -84,7 +95,7 @@ func (dPath *DynamicPath) Stat(gc *client.GoSynClient) (*StatInfo, error) {
 		}, nil
 	}
 
-	statInfo, err := gc.GetStat(dPath.Server.BaseAPIURL, dPath.Server.GUID, dPath.Path)
+	statInfo, err := gc.GetStat(ctx, dPath.Server.BaseAPIURL, dPath.Server.GUID, dPath.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +109,7 @@ func (dPath *DynamicPath) Stat(gc *client.GoSynClient) (*StatInfo, error) {
 
 }
 
-func (dPath *DynamicPath) GetMatches(gc *client.GoSynClient) ([]*DynamicPath, error) {
+func (dPath *DynamicPath) GetMatches(ctx context.Context, gc *client.GoSynClient) ([]*DynamicPath, error) {
 	if !dPath.IsRemote {
 		matches, err := filepath.Glob(dPath.Path)
 		if err != nil {
@@ -113,7 +124,7 @@ func (dPath *DynamicPath) GetMatches(gc *client.GoSynClient) ([]*DynamicPath, er
 			}
 
 			if !stat.IsDir() {
-				fileMatches = append(fileMatches, &DynamicPath{IsRemote: false, Path: dPath.Path})
+				fileMatches = append(fileMatches, &DynamicPath{IsRemote: false, Path: match})
 			}
 		}
 
@@ -124,7 +135,7 @@ func (dPath *DynamicPath) GetMatches(gc *client.GoSynClient) ([]*DynamicPath, er
 		return fileMatches, nil
 	}
 
-	matchesStr, err := gc.GetMatches(dPath.Server.BaseAPIURL, dPath.Server.GUID, dPath.Path)
+	matchesStr, err := gc.GetMatches(ctx, dPath.Server.BaseAPIURL, dPath.Server.GUID, dPath.Path)
 	if err != nil {
 		return nil, fmt.Errorf("error getting matches for '%s': %w", dPath.Path, err)
 	}
@@ -145,11 +156,131 @@ func (dPath *DynamicPath) GetMatches(gc *client.GoSynClient) ([]*DynamicPath, er
 	return fileMatches, nil
 }
 
+func (dPath *DynamicPath) Hash(ctx context.Context, gc *client.GoSynClient) (string, error) {
+	if !dPath.IsRemote {
+		file, err := os.Open(dPath.Path)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+
+		hash := sha256.New()
+		if _, err = io.Copy(hash, file); err != nil {
+			return "", err
+		}
+
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	return gc.GetHash(ctx, dPath.Server.BaseAPIURL, dPath.Server.GUID, dPath.Path)
+}
+
+// CopyTarget returns the path a file named srcName would be written to when copied
+// to dPath, and its current stat. stat is nil if the target does not exist yet.
+func (dPath *DynamicPath) CopyTarget(ctx context.Context, gc *client.GoSynClient, srcName string) (*DynamicPath, *StatInfo, error) {
+	stat, err := dPath.Stat(ctx, gc)
+	if err != nil {
+		if isNotExist(err) {
+			return dPath, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	if !stat.IsDir {
+		return dPath, stat, nil
+	}
+
+	target := &DynamicPath{IsRemote: dPath.IsRemote, Server: dPath.Server, Path: path.Join(dPath.Path, srcName)}
+	stat, err = target.Stat(ctx, gc)
+	if err != nil {
+		if isNotExist(err) {
+			return target, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	return target, stat, nil
+}
+
+type OverwriteMode int
+
+const (
+	// OverwriteDefault errors out on existing files, unless copy is forced
+	OverwriteDefault OverwriteMode = iota
+	// OverwriteUpdate replaces files only if source is newer or has a different size
+	OverwriteUpdate
+	// OverwriteChecksum replaces files only if their contents differ
+	OverwriteChecksum
+	// OverwriteNever skips existing files
+	OverwriteNever
+)
+
+// ShouldReplace reports whether the existing target should be replaced by src under mode.
+func ShouldReplace(ctx context.Context, gc *client.GoSynClient, src *DynamicPath, target *DynamicPath, targetStat *StatInfo, mode OverwriteMode) (bool, error) {
+	switch mode {
+	case OverwriteNever:
+		return false, nil
+	case OverwriteUpdate:
+		srcStat, err := src.Stat(ctx, gc)
+		if err != nil {
+			return false, err
+		}
+		return srcStat.ModTime.After(targetStat.ModTime) || srcStat.Size != targetStat.Size, nil
+	case OverwriteChecksum:
+		srcHash, err := src.Hash(ctx, gc)
+		if err != nil {
+			return false, err
+		}
+
+		targetHash, err := target.Hash(ctx, gc)
+		if err != nil {
+			return false, err
+		}
+		return srcHash != targetHash, nil
+	}
+
+	return true, nil
+}
+
+// CheckAccess fails early if the user does not have level in the space of a remote path.
+// Local paths are always accessible, the OS checks them when they are used.
+func (dPath *DynamicPath) CheckAccess(ctx context.Context, gc *client.GoSynClient, level access.Level) error {
+	if !dPath.IsRemote {
+		return nil
+	}
+
+	levels, err := gc.GetAllSpaces(ctx, dPath.Server.BaseAPIURL, dPath.Server.GUID)
+	if err != nil {
+		return err
+	}
+
+	spaceName, _, _ := strings.Cut(dPath.Path, "/")
+	userLevel := access.None
+	if levelStr, ok := levels[spaceName]; ok {
+		if userLevel, err = access.ParseLevel(levelStr); err != nil {
+			return err
+		}
+	}
+
+	if userLevel < level {
+		return fmt.Errorf("'%s' access to space '%s' is required, user has '%s'", level, dPath.Server.Name+":"+spaceName, userLevel)
+	}
+	return nil
+}
+
+func isNotExist(err error) bool {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusNotFound
+	}
+	return errors.Is(err, os.ErrNotExist)
+}
+
 func isPatternLike(path string) bool {
 	return strings.ContainsRune(path, '?') || strings.ContainsRune(path, '*')
 }
 
-func (dPath *DynamicPath) Reader(gc *client.GoSynClient) (io.ReadCloser, int64, error) {
+func (dPath *DynamicPath) Reader(ctx context.Context, gc *client.GoSynClient) (io.ReadCloser, int64, error) {
 	if !dPath.IsRemote {
 		file, err := os.Open(dPath.Path)
 		if err != nil {
@@ -163,16 +294,23 @@ func (dPath *DynamicPath) Reader(gc *client.GoSynClient) (io.ReadCloser, int64,
 		return file, stat.Size(), nil
 	}
 
-	return gc.GetFile(dPath.Server.BaseAPIURL, dPath.Path, dPath.Server.GUID)
+	return gc.GetFile(ctx, dPath.Server.BaseAPIURL, dPath.Path, dPath.Server.GUID)
 }
 
-func (dPath *DynamicPath) Copy(gc *client.GoSynClient, srcName string, force bool, reader io.Reader) error {
+type CopyOptions struct {
+	Force            bool
+	Backup           conflict.BackupMode
+	RenameOnConflict bool
+}
+
+// Copy writes reader to dPath and returns the path of the written file
+func (dPath *DynamicPath) Copy(ctx context.Context, gc *client.GoSynClient, srcName string, opts CopyOptions, reader io.Reader) (*DynamicPath, error) {
 	if !dPath.IsRemote {
 		writeDest := dPath.Path
 		writeStat, err := os.Stat(dPath.Path)
 		destExist := !errors.Is(err, os.ErrNotExist)
 		if err != nil && destExist {
-			return err
+			return nil, err
 		}
 
 		if err == nil && writeStat.IsDir() {
@@ -180,31 +318,90 @@ func (dPath *DynamicPath) Copy(gc *client.GoSynClient, srcName string, force boo
 			writeStat, err = os.Stat(writeDest)
 			destExist = !errors.Is(err, os.ErrNotExist)
 			if err != nil && destExist {
-				return err
+				return nil, err
 			}
 
 			if err == nil && writeStat.IsDir() {
-				return fmt.Errorf("path '%s' is a directory", writeDest)
+				return nil, fmt.Errorf("path '%s' is a directory", writeDest)
 			}
 		}
 
-		if destExist && !force {
-			return fmt.Errorf("file '%s' already exists", writeDest)
+		if destExist && !opts.Force && !opts.RenameOnConflict {
+			return nil, &existsError{path: writeDest}
 		}
 
-		w, err := os.Create(writeDest)
+		// the file is written next to writeDest and moved there once complete, an interrupted copy leaves nothing behind.
+		// The local directory is not a space, it is its own root.
+		destDir := filepath.Dir(writeDest)
+		dir, err := confine.OpenDir(destDir, destDir, confine.SymlinksInside)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		defer dir.Close()
 
-		if _, err = io.Copy(w, reader); err != nil {
-			return err
+		partialName := conflict.PartialName(filepath.Base(writeDest))
+		w, err := dir.Open(partialName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err != nil {
+			return nil, err
 		}
 
-		return nil
+		_, err = io.Copy(w, &contextReader{ctx: ctx, r: reader})
+		if err == nil {
+			err = w.Sync()
+		}
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			dir.Remove(partialName)
+			return nil, err
+		}
+
+		backup := conflict.BackupNone
+		if destExist {
+			backup = opts.Backup
+		}
+		placedName, err := conflict.Place(dir, partialName, filepath.Base(writeDest), backup, destExist && opts.RenameOnConflict)
+		if err != nil {
+			dir.Remove(partialName)
+			return nil, err
+		}
+
+		return &DynamicPath{IsRemote: false, Path: path.Join(destDir, placedName)}, nil
+	}
+
+	putOpts := client.PutNewOptions{Force: opts.Force, Backup: opts.Backup, RenameOnConflict: opts.RenameOnConflict}
+	finalPath, err := gc.PutNewFile(ctx, dPath.Server.BaseAPIURL, dPath.Path, dPath.Server.GUID, srcName, putOpts, reader)
+	if err != nil {
+		return nil, err
 	}
 
-	return gc.PutNewFile(dPath.Server.BaseAPIURL, dPath.Path, dPath.Server.GUID, srcName, force, reader)
+	return &DynamicPath{IsRemote: true, Server: dPath.Server, Path: finalPath}, nil
+}
+
+// contextReader stops reading once ctx is done, so local copies can be cancelled like remote ones
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+type existsError struct {
+	path string
+}
+
+func (e *existsError) Error() string {
+	return fmt.Sprintf("file '%s' already exists", e.path)
+}
+
+func (e *existsError) Unwrap() error {
+	return os.ErrExist
 }
 
 func (dPath *DynamicPath) String() string {
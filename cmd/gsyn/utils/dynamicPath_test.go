@@ -1,12 +1,16 @@
 package utils
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/aigic8/gosyn/api/client"
+	"github.com/aigic8/gosyn/api/conflict"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -82,6 +86,7 @@ type dynamicPathCopyTestCase struct {
 	Name          string
 	From          *DynamicPath
 	To            *DynamicPath
+	Opts          CopyOptions
 	ErrExpected   bool
 	ExpectedFiles []string
 }
@@ -105,22 +110,26 @@ func TestDynamicPathCopy(t *testing.T) {
 	appPath := newLocalDP("app.txt", base)
 	normalFiles := []string{path.Join(base, "app2.txt")}
 	toDirFiles := []string{path.Join(base, "dist/app.txt")}
+	backupFiles := []string{path.Join(base, "dist/exist.txt"), path.Join(base, "dist/exist.txt~")}
+	renameFiles := []string{path.Join(base, "dist/exist.txt"), path.Join(base, "dist/exist (1).txt")}
 	testCases := []dynamicPathCopyTestCase{
 		{Name: "normal", From: appPath, To: newLocalDP("app2.txt", base), ErrExpected: false, ExpectedFiles: normalFiles},
 		{Name: "toDir", From: appPath, To: newLocalDP("dist", base), ErrExpected: false, ExpectedFiles: toDirFiles},
 		{Name: "toDirDoesNotExist", From: appPath, To: newLocalDP("nowhere/app.txt", base), ErrExpected: true},
 		{Name: "toAlreadyFile", From: appPath, To: newLocalDP("dist/exist.txt", base), ErrExpected: true},
+		{Name: "toAlreadyFileBackup", From: appPath, To: newLocalDP("dist/exist.txt", base), Opts: CopyOptions{Force: true, Backup: conflict.BackupSimple}, ErrExpected: false, ExpectedFiles: backupFiles},
+		{Name: "toAlreadyFileRename", From: appPath, To: newLocalDP("dist/exist.txt", base), Opts: CopyOptions{RenameOnConflict: true}, ErrExpected: false, ExpectedFiles: renameFiles},
 	}
 
 	gc := &client.GoSynClient{C: &http.Client{}}
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
-			reader, _, err := tc.From.Reader(gc)
+			reader, _, err := tc.From.Reader(context.Background(), gc)
 			if err != nil {
 				panic(err)
 			}
 
-			err = tc.To.Copy(gc, path.Base(tc.From.Path), false, reader)
+			_, err = tc.To.Copy(context.Background(), gc, path.Base(tc.From.Path), tc.Opts, reader)
 			if tc.ErrExpected {
 				assert.NotNil(t, err)
 			} else {
@@ -133,6 +142,95 @@ func TestDynamicPathCopy(t *testing.T) {
 	}
 }
 
+func TestDynamicPathCopyCancelled(t *testing.T) {
+	base := t.TempDir()
+
+	err := MakeFiles(base, []FileInfo{{Path: "exist.txt", Data: []byte("I DO EXIST!")}})
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.Write([]byte("HALF OF"))
+		cancel()
+		pw.Write([]byte(" THE FILE"))
+		pw.Close()
+	}()
+
+	gc := &client.GoSynClient{C: &http.Client{}}
+	_, err = newLocalDP("exist.txt", base).Copy(ctx, gc, "exist.txt", CopyOptions{Force: true}, pr)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	data, err := os.ReadFile(path.Join(base, "exist.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(data), "I DO EXIST!")
+
+	entries, err := os.ReadDir(base)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), 1)
+}
+
+type shouldReplaceTestCase struct {
+	Name     string
+	Src      *DynamicPath
+	Target   *DynamicPath
+	Mode     OverwriteMode
+	Expected bool
+}
+
+func TestShouldReplace(t *testing.T) {
+	base := t.TempDir()
+
+	err := MakeFiles(base, []FileInfo{
+		{Path: "new.txt", Data: []byte("HELLO THERE")},
+		{Path: "old.txt", Data: []byte("HELLO THERE")},
+		{Path: "other.txt", Data: []byte("HELLO THREE")},
+		{Path: "short.txt", Data: []byte("HELLO")},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
+	for _, file := range []string{"old.txt", "short.txt"} {
+		if err = os.Chtimes(path.Join(base, file), now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+			panic(err)
+		}
+	}
+	for _, file := range []string{"new.txt", "other.txt"} {
+		if err = os.Chtimes(path.Join(base, file), now, now); err != nil {
+			panic(err)
+		}
+	}
+
+	newDP := newLocalDP("new.txt", base)
+	testCases := []shouldReplaceTestCase{
+		{Name: "noClobber", Src: newDP, Target: newLocalDP("old.txt", base), Mode: OverwriteNever, Expected: false},
+		{Name: "updateNewer", Src: newDP, Target: newLocalDP("old.txt", base), Mode: OverwriteUpdate, Expected: true},
+		{Name: "updateOlder", Src: newLocalDP("old.txt", base), Target: newDP, Mode: OverwriteUpdate, Expected: false},
+		{Name: "updateSizeDiffers", Src: newLocalDP("old.txt", base), Target: newLocalDP("short.txt", base), Mode: OverwriteUpdate, Expected: true},
+		{Name: "checksumSame", Src: newDP, Target: newLocalDP("old.txt", base), Mode: OverwriteChecksum, Expected: false},
+		{Name: "checksumDiffers", Src: newDP, Target: newLocalDP("other.txt", base), Mode: OverwriteChecksum, Expected: true},
+	}
+
+	gc := &client.GoSynClient{C: &http.Client{}}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			targetStat, err := tc.Target.Stat(context.Background(), gc)
+			if err != nil {
+				panic(err)
+			}
+
+			replace, err := ShouldReplace(context.Background(), gc, tc.Src, tc.Target, targetStat, tc.Mode)
+			assert.Nil(t, err)
+			assert.Equal(t, replace, tc.Expected)
+		})
+	}
+}
+
 func newLocalDP(rawPath string, base string) *DynamicPath {
 	dPath, err := NewDynamicPath(rawPath, base, map[string]*ServerInfo{})
 	if err != nil {
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// KnownServers pins server certificates on first use, like SSH known_hosts.
+// Each line of the file is a server host (with port) and the SHA-256 fingerprint of its certificate.
+type KnownServers struct {
+	mu           sync.Mutex
+	filePath     string
+	fingerprints map[string]string
+	// Confirm asks the user whether to trust the fingerprint of a server seen for the first time
+	Confirm func(host, fingerprint string) bool
+}
+
+type FingerprintMismatchError struct {
+	Host     string
+	Known    string
+	Got      string
+	FilePath string
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	return fmt.Sprintf("CERTIFICATE OF SERVER '%s' HAS CHANGED!\n"+
+		"Someone could be eavesdropping on you (man-in-the-middle attack), or the server certificate was replaced.\n"+
+		"known fingerprint: %s\n"+
+		"got fingerprint:   %s\n"+
+		"If the change is expected, remove the line of '%s' from '%s'.",
+		e.Host, e.Known, e.Got, e.Host, e.FilePath)
+}
+
+var ErrServerNotTrusted = errors.New("server is not trusted")
+
+func KnownServersPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(homeDir, ".config/gsyn/known_servers"), nil
+}
+
+// LoadKnownServers reads the known servers file, a missing file means no server is known yet
+func LoadKnownServers(filePath string, confirm func(host, fingerprint string) bool) (*KnownServers, error) {
+	ks := &KnownServers{filePath: filePath, fingerprints: map[string]string{}, Confirm: confirm}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ks, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected '<host> <fingerprint>'", filePath, lineNum)
+		}
+		ks.fingerprints[fields[0]] = fields[1]
+	}
+
+	return ks, scanner.Err()
+}
+
+// VerifyFunc returns a tls.Config VerifyPeerCertificate callback which only checks the pinned fingerprint of host,
+// hostname and IP SANs are ignored. It never asks the user, servers must be trusted before (see TrustOnFirstUse),
+// since the timeouts of the handshake keep running while the user answers.
+func (ks *KnownServers) VerifyFunc(host string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("server sent no certificate")
+		}
+		return ks.Verify(host, rawCerts[0])
+	}
+}
+
+// Known reports whether a fingerprint is pinned for host
+func (ks *KnownServers) Known(host string) bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	_, ok := ks.fingerprints[host]
+	return ok
+}
+
+// Verify checks rawCert against the pinned fingerprint of host, hosts without one are not trusted
+func (ks *KnownServers) Verify(host string, rawCert []byte) error {
+	fingerprint := certFingerprint(rawCert)
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	known, ok := ks.fingerprints[host]
+	if !ok {
+		return fmt.Errorf("%w: '%s' is not known", ErrServerNotTrusted, host)
+	}
+	if known != fingerprint {
+		return &FingerprintMismatchError{Host: host, Known: known, Got: fingerprint, FilePath: ks.filePath}
+	}
+	return nil
+}
+
+// TrustOnFirstUse verifies rawCert like Verify, but asks the user through Confirm whether to trust a host seen for
+// the first time, and pins its fingerprint if they do
+func (ks *KnownServers) TrustOnFirstUse(host string, rawCert []byte) error {
+	fingerprint := certFingerprint(rawCert)
+
+	// held while confirming, so a new server is asked about only once
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if known, ok := ks.fingerprints[host]; ok {
+		if known != fingerprint {
+			return &FingerprintMismatchError{Host: host, Known: known, Got: fingerprint, FilePath: ks.filePath}
+		}
+		return nil
+	}
+
+	if ks.Confirm == nil || !ks.Confirm(host, fingerprint) {
+		return fmt.Errorf("%w: '%s' with fingerprint %s", ErrServerNotTrusted, host, fingerprint)
+	}
+
+	if err := ks.add(host, fingerprint); err != nil {
+		return fmt.Errorf("saving fingerprint of '%s': %w", host, err)
+	}
+	return nil
+}
+
+func certFingerprint(rawCert []byte) string {
+	sum := sha256.Sum256(rawCert)
+	return hex.EncodeToString(sum[:])
+}
+
+// add must be called with ks.mu held
+func (ks *KnownServers) add(host, fingerprint string) error {
+	if err := os.MkdirAll(path.Dir(ks.filePath), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(ks.filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = fmt.Fprintf(file, "%s %s\n", host, fingerprint); err != nil {
+		return err
+	}
+
+	ks.fingerprints[host] = fingerprint
+	return nil
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKnownServers(t *testing.T) {
+	filePath := path.Join(t.TempDir(), "gsyn/known_servers")
+	cert := []byte("shine on you crazy diamond")
+	otherCert := []byte("wish you were here")
+
+	confirms := 0
+	accept := func(host, fingerprint string) bool {
+		confirms++
+		return true
+	}
+	reject := func(host, fingerprint string) bool {
+		return false
+	}
+
+	ks, err := LoadKnownServers(filePath, reject)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(ks.TrustOnFirstUse("1.2.3.4:8686", cert), ErrServerNotTrusted))
+	assert.NoFileExists(t, filePath)
+
+	// verifying never asks, unknown servers are not trusted
+	ks, err = LoadKnownServers(filePath, accept)
+	assert.Nil(t, err)
+	assert.True(t, errors.Is(ks.Verify("1.2.3.4:8686", cert), ErrServerNotTrusted))
+	assert.False(t, ks.Known("1.2.3.4:8686"))
+	assert.Equal(t, confirms, 0)
+
+	assert.Nil(t, ks.TrustOnFirstUse("1.2.3.4:8686", cert))
+	assert.Nil(t, ks.TrustOnFirstUse("1.2.3.4:8686", cert))
+	assert.Nil(t, ks.Verify("1.2.3.4:8686", cert))
+	assert.True(t, ks.Known("1.2.3.4:8686"))
+	assert.Equal(t, confirms, 1)
+
+	// fingerprints are stored and checked after reloading, without asking again
+	ks, err = LoadKnownServers(filePath, reject)
+	assert.Nil(t, err)
+	assert.Nil(t, ks.Verify("1.2.3.4:8686", cert))
+
+	var mismatchErr *FingerprintMismatchError
+	assert.True(t, errors.As(ks.Verify("1.2.3.4:8686", otherCert), &mismatchErr))
+	assert.Equal(t, mismatchErr.Host, "1.2.3.4:8686")
+	assert.True(t, errors.As(ks.TrustOnFirstUse("1.2.3.4:8686", otherCert), &mismatchErr))
+
+	if err := os.WriteFile(filePath, []byte("bad line with fields\n"), 0600); err != nil {
+		panic(err)
+	}
+	_, err = LoadKnownServers(filePath, reject)
+	assert.NotNil(t, err)
+}
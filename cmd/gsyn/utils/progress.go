@@ -0,0 +1,281 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+type ProgressMode int
+
+const (
+	// ProgressTTY redraws a multi-line display in place
+	ProgressTTY ProgressMode = iota
+	// ProgressPlain prints a plain line periodically, for when output is not a terminal
+	ProgressPlain
+	// ProgressQuiet does not print anything
+	ProgressQuiet
+)
+
+const (
+	ttyRenderInterval   = 100 * time.Millisecond
+	plainRenderInterval = 5 * time.Second
+	progressBarWidth    = 20
+)
+
+// Progress is a single progress display for all the files copied by multiple workers
+type Progress struct {
+	mu           sync.Mutex
+	out          io.Writer
+	mode         ProgressMode
+	workers      []*FileProgress
+	totalFiles   int
+	doneFiles    int
+	startedFiles int
+	startedBytes int64
+	doneBytes    int64
+	start        time.Time
+	drawnLines   int
+	// termWidth is the number of columns of the terminal, zero if unknown
+	termWidth func() int
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+type FileProgress struct {
+	p      *Progress
+	worker int
+	Name   string
+	Size   int64
+	Done   int64
+}
+
+func NewProgress(out io.Writer, mode ProgressMode, totalFiles, workers int) *Progress {
+	return &Progress{
+		out:        out,
+		mode:       mode,
+		workers:    make([]*FileProgress, workers),
+		totalFiles: totalFiles,
+		termWidth:  func() int { return terminalWidth(out) },
+		stop:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+func (p *Progress) Start() {
+	p.start = time.Now()
+	if p.mode == ProgressQuiet {
+		close(p.stopped)
+		return
+	}
+
+	interval := ttyRenderInterval
+	if p.mode == ProgressPlain {
+		interval = plainRenderInterval
+	}
+
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.render()
+			case <-p.stop:
+				p.render()
+				return
+			}
+		}
+	}()
+}
+
+// Stop renders the final state and stops the display
+func (p *Progress) Stop() {
+	close(p.stop)
+	<-p.stopped
+}
+
+// StartFile shows a file as the current file of the worker. Size is -1 if unknown.
+func (p *Progress) StartFile(worker int, name string, size int64) *FileProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fp := &FileProgress{p: p, worker: worker, Name: name, Size: size}
+	p.workers[worker] = fp
+	p.startedFiles++
+	if size > 0 {
+		p.startedBytes += size
+	}
+	return fp
+}
+
+// SkipFile counts a file as done without transferring it
+func (p *Progress) SkipFile() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.doneFiles++
+}
+
+func (fp *FileProgress) Write(b []byte) (int, error) {
+	fp.p.mu.Lock()
+	defer fp.p.mu.Unlock()
+	fp.Done += int64(len(b))
+	fp.p.doneBytes += int64(len(b))
+	return len(b), nil
+}
+
+func (fp *FileProgress) Finish() {
+	fp.p.mu.Lock()
+	defer fp.p.mu.Unlock()
+	fp.p.doneFiles++
+	if fp.p.workers[fp.worker] == fp {
+		fp.p.workers[fp.worker] = nil
+	}
+}
+
+func (p *Progress) render() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.mode == ProgressPlain {
+		fmt.Fprintln(p.out, p.totalLine(false))
+		return
+	}
+
+	var b strings.Builder
+	if p.drawnLines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", p.drawnLines)
+	}
+
+	// lines wider than the terminal wrap, then moving up by the number of lines drawn misses some of them
+	width := p.termWidth()
+	lines := 0
+	for i, fp := range p.workers {
+		if fp == nil {
+			continue
+		}
+		b.WriteString("\x1b[2K")
+		b.WriteString(truncateLine(fp.line(i), width))
+		b.WriteString("\n")
+		lines++
+	}
+	b.WriteString("\x1b[2K")
+	b.WriteString(truncateLine(p.totalLine(true), width))
+	b.WriteString("\n")
+	lines++
+
+	// clearing lines of workers which went idle since the last render
+	for i := lines; i < p.drawnLines; i++ {
+		b.WriteString("\x1b[2K\n")
+	}
+	if p.drawnLines > lines {
+		fmt.Fprintf(&b, "\x1b[%dA", p.drawnLines-lines)
+	}
+
+	p.drawnLines = lines
+	io.WriteString(p.out, b.String())
+}
+
+func (fp *FileProgress) line(worker int) string {
+	if fp.Size < 0 {
+		return fmt.Sprintf(" [%d] %s  %s", worker+1, fp.Name, FormatBytes(fp.Done))
+	}
+	return fmt.Sprintf(" [%d] %s  %s / %s  %d%%", worker+1, fp.Name, FormatBytes(fp.Done), FormatBytes(fp.Size), percent(fp.Done, fp.Size))
+}
+
+// totalLine must be called with p.mu held
+func (p *Progress) totalLine(withBar bool) string {
+	elapsed := time.Since(p.start)
+	throughput := float64(0)
+	if elapsed > 0 {
+		throughput = float64(p.doneBytes) / elapsed.Seconds()
+	}
+
+	// sizes are only known after files start, so estimating the rest by the average size
+	estTotalBytes := p.startedBytes
+	if p.startedFiles > 0 && p.totalFiles > p.doneFiles+p.activeFiles() {
+		notStarted := p.totalFiles - p.doneFiles - p.activeFiles()
+		estTotalBytes += p.startedBytes / int64(p.startedFiles) * int64(notStarted)
+	}
+	if estTotalBytes < p.doneBytes {
+		estTotalBytes = p.doneBytes
+	}
+
+	eta := "--"
+	if throughput > 0 {
+		eta = (time.Duration(float64(estTotalBytes-p.doneBytes)/throughput) * time.Second).Round(time.Second).String()
+	}
+
+	line := fmt.Sprintf("%d/%d files  %s / %s  %s/s  ETA %s",
+		p.doneFiles, p.totalFiles, FormatBytes(p.doneBytes), FormatBytes(estTotalBytes), FormatBytes(int64(throughput)), eta)
+	if !withBar {
+		return line
+	}
+
+	filled := 0
+	if estTotalBytes > 0 {
+		filled = int(p.doneBytes * progressBarWidth / estTotalBytes)
+	}
+	return fmt.Sprintf(" [%s%s] %s", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled), line)
+}
+
+// truncateLine cuts line to width columns, ending it with '…' if it is cut. A width of zero keeps it whole.
+func truncateLine(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+
+	runes := []rune(line)
+	return string(runes[:width-1]) + "…"
+}
+
+// terminalWidth is the number of columns of the terminal out is, zero if it is not one
+func terminalWidth(out io.Writer) int {
+	file, ok := out.(*os.File)
+	if !ok {
+		return 0
+	}
+	width, _, err := term.GetSize(int(file.Fd()))
+	if err != nil {
+		return 0
+	}
+	return width
+}
+
+func (p *Progress) activeFiles() int {
+	active := 0
+	for _, fp := range p.workers {
+		if fp != nil {
+			active++
+		}
+	}
+	return active
+}
+
+func percent(done, total int64) int64 {
+	if total == 0 {
+		return 100
+	}
+	return done * 100 / total
+}
+
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, FormatBytes(0), "0 B")
+	assert.Equal(t, FormatBytes(1023), "1023 B")
+	assert.Equal(t, FormatBytes(1536), "1.5 KiB")
+	assert.Equal(t, FormatBytes(5*1024*1024), "5.0 MiB")
+}
+
+func TestProgressPlain(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := NewProgress(out, ProgressPlain, 3, 2)
+	p.Start()
+
+	fp := p.StartFile(0, "server:space/time.txt", 2048)
+	fp.Write(make([]byte, 2048))
+	fp.Finish()
+	p.SkipFile()
+	p.Stop()
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, len(lines), 1)
+	assert.True(t, strings.HasPrefix(lines[0], "2/3 files  2.0 KiB / 4.0 KiB"))
+	assert.NotContains(t, out.String(), "\x1b[")
+}
+
+func TestProgressQuiet(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := NewProgress(out, ProgressQuiet, 1, 1)
+	p.Start()
+
+	fp := p.StartFile(0, "server:space/time.txt", 10)
+	fp.Write(make([]byte, 10))
+	fp.Finish()
+	p.Stop()
+
+	assert.Equal(t, out.Len(), 0)
+}
+
+func TestProgressTTYWidth(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := NewProgress(out, ProgressTTY, 1, 1)
+	p.termWidth = func() int { return 30 }
+	p.Start()
+
+	fp := p.StartFile(0, "server:space/shine-on-you-crazy-diamond.flac", 2048)
+	fp.Write(make([]byte, 1024))
+	p.Stop()
+
+	escapes := regexp.MustCompile("\x1b\\[[0-9]*[AK]")
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		assert.LessOrEqual(t, utf8.RuneCountInString(escapes.ReplaceAllString(line, "")), 30)
+	}
+	assert.Contains(t, out.String(), " [1] server:space/shine-on-yo…")
+	assert.Equal(t, truncateLine("time.txt", 0), "time.txt")
+	assert.Equal(t, truncateLine("time.txt", 8), "time.txt")
+	assert.Equal(t, truncateLine("time.txt", 5), "time…")
+}
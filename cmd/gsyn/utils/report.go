@@ -0,0 +1,425 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/aigic8/gosyn/api/client"
+	"github.com/fatih/color"
+)
+
+// Reporter reports the state of a copy to the user
+type Reporter interface {
+	Plan(srcs []*DynamicPath, matches []*DynamicPath, dest *DynamicPath)
+	FileStart(worker int, src, dest *DynamicPath, size int64) FileReporter
+	FileSkipped(src, target *DynamicPath, reason string)
+	FileError(src, dest *DynamicPath, err error)
+	Finish() Summary
+}
+
+// FileReporter is written the transferred bytes of a single file
+type FileReporter interface {
+	io.Writer
+	Done(written *DynamicPath)
+	Fail(err error)
+}
+
+type Summary struct {
+	Copied   int
+	Skipped  int
+	Failed   int
+	Bytes    int64
+	Duration time.Duration
+}
+
+// ErrorCode returns a stable, machine-readable code for an error
+func ErrorCode(err error) string {
+	var apiErr *client.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.StatusCode == http.StatusNotFound:
+			return "not_found"
+		case apiErr.StatusCode == http.StatusUnauthorized:
+			return "unauthorized"
+		case apiErr.StatusCode == http.StatusForbidden:
+			return "permission_denied"
+		case apiErr.StatusCode == http.StatusTooManyRequests:
+			return "rate_limited"
+		case apiErr.StatusCode == http.StatusBadRequest:
+			return "bad_request"
+		case apiErr.StatusCode >= 500:
+			return "server_error"
+		}
+		return "api_error"
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, fs.ErrExist):
+		return "already_exists"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission_denied"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "unknown"
+}
+
+type skippedFile struct {
+	Src    string
+	Reason string
+}
+
+type renamedFile struct {
+	Src  string
+	Dest string
+}
+
+type failedFile struct {
+	Src string
+	Err error
+}
+
+var (
+	errPrepend    = color.New(color.FgRed).Sprint(" ERROR ")
+	skipPrepend   = color.New(color.FgCyan).Sprint(" SKIP ")
+	renamePrepend = color.New(color.FgBlue).Sprint(" RENAME ")
+)
+
+// TextReporter shows a progress display while copying and a summary for humans after that
+type TextReporter struct {
+	mu       sync.Mutex
+	out      io.Writer
+	mode     ProgressMode
+	progress *Progress
+	workers  int
+	start    time.Time
+	copied   int
+	skipped  []skippedFile
+	renamed  []renamedFile
+	failed   []failedFile
+}
+
+func NewTextReporter(out io.Writer, mode ProgressMode, workers int) *TextReporter {
+	return &TextReporter{out: out, mode: mode, workers: workers}
+}
+
+func (r *TextReporter) Plan(srcs []*DynamicPath, matches []*DynamicPath, dest *DynamicPath) {
+	r.start = time.Now()
+	r.progress = NewProgress(r.out, r.mode, len(matches), r.workers)
+	r.progress.Start()
+}
+
+func (r *TextReporter) FileStart(worker int, src, dest *DynamicPath, size int64) FileReporter {
+	return &textFileReporter{r: r, fp: r.progress.StartFile(worker, src.String(), size), src: src, dest: dest}
+}
+
+func (r *TextReporter) FileSkipped(src, target *DynamicPath, reason string) {
+	r.progress.SkipFile()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.skipped = append(r.skipped, skippedFile{Src: src.String(), Reason: reason})
+}
+
+func (r *TextReporter) FileError(src, dest *DynamicPath, err error) {
+	r.progress.SkipFile()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = append(r.failed, failedFile{Src: src.String(), Err: err})
+}
+
+func (r *TextReporter) Finish() Summary {
+	r.progress.Stop()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, file := range r.renamed {
+		fmt.Fprint(r.out, renamePrepend)
+		fmt.Fprintf(r.out, "'%s' was saved as '%s'\n", file.Src, file.Dest)
+	}
+	for _, file := range r.skipped {
+		fmt.Fprint(r.out, skipPrepend)
+		fmt.Fprintf(r.out, "'%s': %s\n", file.Src, file.Reason)
+	}
+	for _, file := range r.failed {
+		fmt.Fprint(r.out, errPrepend)
+		fmt.Fprintf(r.out, "'%s': %s\n", file.Src, file.Err)
+	}
+
+	summary := Summary{
+		Copied:   r.copied,
+		Skipped:  len(r.skipped),
+		Failed:   len(r.failed),
+		Bytes:    r.progress.doneBytes,
+		Duration: time.Since(r.start),
+	}
+	fmt.Fprintf(r.out, "copied %d files (%s) in %s, skipped %d files, %d failed\n",
+		summary.Copied, FormatBytes(summary.Bytes), summary.Duration.Round(time.Millisecond), summary.Skipped, summary.Failed)
+	return summary
+}
+
+type textFileReporter struct {
+	r    *TextReporter
+	fp   *FileProgress
+	src  *DynamicPath
+	dest *DynamicPath
+}
+
+func (fr *textFileReporter) Write(b []byte) (int, error) {
+	return fr.fp.Write(b)
+}
+
+func (fr *textFileReporter) Done(written *DynamicPath) {
+	fr.fp.Finish()
+
+	fr.r.mu.Lock()
+	defer fr.r.mu.Unlock()
+	fr.r.copied++
+	if isRenamed(fr.src, fr.dest, written) {
+		fr.r.renamed = append(fr.r.renamed, renamedFile{Src: fr.src.String(), Dest: written.String()})
+	}
+}
+
+func (fr *textFileReporter) Fail(err error) {
+	fr.fp.Finish()
+
+	fr.r.mu.Lock()
+	defer fr.r.mu.Unlock()
+	fr.r.failed = append(fr.r.failed, failedFile{Src: fr.src.String(), Err: err})
+}
+
+// isRenamed reports whether the file was written to a different name than requested because of a conflict.
+// written is either dest itself, a file inside dest or a renamed file.
+func isRenamed(src, dest, written *DynamicPath) bool {
+	destPath := path.Clean(dest.Path)
+	writtenPath := path.Clean(written.Path)
+	return writtenPath != destPath && writtenPath != path.Join(destPath, path.Base(src.Path))
+}
+
+const jsonProgressInterval = time.Second
+
+// JSONReporter writes one JSON event per line, for tools wrapping gsyn
+type JSONReporter struct {
+	mu         sync.Mutex
+	enc        *json.Encoder
+	start      time.Time
+	totalFiles int
+	copied     int
+	skipped    int
+	failed     int
+	bytes      int64
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+type jsonEvent struct {
+	Event          string   `json:"event"`
+	Time           string   `json:"time"`
+	Worker         *int     `json:"worker,omitempty"`
+	Source         string   `json:"source,omitempty"`
+	Destination    string   `json:"destination,omitempty"`
+	Sources        []string `json:"sources,omitempty"`
+	Files          []string `json:"files,omitempty"`
+	Size           *int64   `json:"size,omitempty"`
+	Bytes          *int64   `json:"bytes,omitempty"`
+	DurationMs     *int64   `json:"duration_ms,omitempty"`
+	FilesTotal     *int     `json:"files_total,omitempty"`
+	FilesDone      *int     `json:"files_done,omitempty"`
+	FilesCopied    *int     `json:"files_copied,omitempty"`
+	FilesSkipped   *int     `json:"files_skipped,omitempty"`
+	FilesFailed    *int     `json:"files_failed,omitempty"`
+	BytesPerSecond *int64   `json:"bytes_per_second,omitempty"`
+	Renamed        *bool    `json:"renamed,omitempty"`
+	Reason         string   `json:"reason,omitempty"`
+	Message        string   `json:"message,omitempty"`
+	Code           string   `json:"code,omitempty"`
+}
+
+func NewJSONReporter(out io.Writer) *JSONReporter {
+	return &JSONReporter{enc: json.NewEncoder(out), stop: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (r *JSONReporter) emit(e jsonEvent) {
+	e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	r.enc.Encode(&e)
+}
+
+func (r *JSONReporter) Plan(srcs []*DynamicPath, matches []*DynamicPath, dest *DynamicPath) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.start = time.Now()
+	r.totalFiles = len(matches)
+	r.emit(jsonEvent{
+		Event:       "plan",
+		Sources:     pathStrings(srcs),
+		Files:       pathStrings(matches),
+		Destination: dest.String(),
+		FilesTotal:  &r.totalFiles,
+	})
+
+	go func() {
+		defer close(r.stopped)
+		ticker := time.NewTicker(jsonProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.emitProgress()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *JSONReporter) emitProgress() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	filesDone := r.copied + r.skipped + r.failed
+	bytes := r.bytes
+	bytesPerSecond := int64(0)
+	if elapsed := time.Since(r.start).Seconds(); elapsed > 0 {
+		bytesPerSecond = int64(float64(bytes) / elapsed)
+	}
+	r.emit(jsonEvent{Event: "progress", FilesTotal: &r.totalFiles, FilesDone: &filesDone, Bytes: &bytes, BytesPerSecond: &bytesPerSecond})
+}
+
+func (r *JSONReporter) FileStart(worker int, src, dest *DynamicPath, size int64) FileReporter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.emit(jsonEvent{Event: "file-start", Worker: &worker, Source: src.String(), Destination: dest.String(), Size: &size})
+	return &jsonFileReporter{r: r, worker: worker, src: src, dest: dest, size: size, start: time.Now()}
+}
+
+func (r *JSONReporter) FileSkipped(src, target *DynamicPath, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.skipped++
+	r.emit(jsonEvent{Event: "file-skipped", Source: src.String(), Destination: target.String(), Reason: reason})
+}
+
+func (r *JSONReporter) FileError(src, dest *DynamicPath, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failed++
+	r.emit(jsonEvent{Event: "file-error", Source: src.String(), Destination: dest.String(), Message: err.Error(), Code: ErrorCode(err)})
+}
+
+func (r *JSONReporter) Finish() Summary {
+	close(r.stop)
+	<-r.stopped
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	summary := Summary{Copied: r.copied, Skipped: r.skipped, Failed: r.failed, Bytes: r.bytes, Duration: time.Since(r.start)}
+	durationMs := summary.Duration.Milliseconds()
+	r.emit(jsonEvent{
+		Event:        "summary",
+		FilesTotal:   &r.totalFiles,
+		FilesCopied:  &summary.Copied,
+		FilesSkipped: &summary.Skipped,
+		FilesFailed:  &summary.Failed,
+		Bytes:        &summary.Bytes,
+		DurationMs:   &durationMs,
+	})
+	return summary
+}
+
+// Error writes an event for errors not related to a single file
+func (r *JSONReporter) Error(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emit(jsonEvent{Event: "error", Message: err.Error(), Code: ErrorCode(err)})
+}
+
+func (r *JSONReporter) Warning(message string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.emit(jsonEvent{Event: "warning", Message: message})
+}
+
+type jsonFileReporter struct {
+	r      *JSONReporter
+	worker int
+	src    *DynamicPath
+	dest   *DynamicPath
+	size   int64
+	bytes  int64
+	start  time.Time
+}
+
+func (fr *jsonFileReporter) Write(b []byte) (int, error) {
+	fr.r.mu.Lock()
+	defer fr.r.mu.Unlock()
+	fr.bytes += int64(len(b))
+	fr.r.bytes += int64(len(b))
+	return len(b), nil
+}
+
+func (fr *jsonFileReporter) Done(written *DynamicPath) {
+	fr.r.mu.Lock()
+	defer fr.r.mu.Unlock()
+
+	fr.r.copied++
+	durationMs := time.Since(fr.start).Milliseconds()
+	renamed := isRenamed(fr.src, fr.dest, written)
+	fr.r.emit(jsonEvent{
+		Event:       "file-done",
+		Worker:      &fr.worker,
+		Source:      fr.src.String(),
+		Destination: written.String(),
+		Size:        &fr.size,
+		Bytes:       &fr.bytes,
+		DurationMs:  &durationMs,
+		Renamed:     &renamed,
+	})
+}
+
+func (fr *jsonFileReporter) Fail(err error) {
+	fr.r.mu.Lock()
+	defer fr.r.mu.Unlock()
+
+	fr.r.failed++
+	durationMs := time.Since(fr.start).Milliseconds()
+	fr.r.emit(jsonEvent{
+		Event:       "file-error",
+		Worker:      &fr.worker,
+		Source:      fr.src.String(),
+		Destination: fr.dest.String(),
+		Bytes:       &fr.bytes,
+		DurationMs:  &durationMs,
+		Message:     err.Error(),
+		Code:        ErrorCode(err),
+	})
+}
+
+func pathStrings(dPaths []*DynamicPath) []string {
+	res := make([]string, 0, len(dPaths))
+	for _, dPath := range dPaths {
+		res = append(res, dPath.String())
+	}
+	return res
+}
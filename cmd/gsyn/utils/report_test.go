@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aigic8/gosyn/api/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONReporter(t *testing.T) {
+	out := &bytes.Buffer{}
+	r := NewJSONReporter(out)
+
+	server := &ServerInfo{Name: "myserver"}
+	src := &DynamicPath{IsRemote: true, Server: server, Path: "space/time.txt"}
+	skippedSrc := &DynamicPath{IsRemote: true, Server: server, Path: "space/money.txt"}
+	failedSrc := &DynamicPath{IsRemote: true, Server: server, Path: "space/echoes.txt"}
+	dest := &DynamicPath{Path: "/home/music"}
+
+	r.Plan([]*DynamicPath{src}, []*DynamicPath{src, skippedSrc, failedSrc}, dest)
+	fr := r.FileStart(0, src, dest, 5)
+	fr.Write([]byte("hello"))
+	fr.Done(&DynamicPath{Path: "/home/music/time.txt"})
+	r.FileSkipped(skippedSrc, dest, "already exists")
+	r.FileError(failedSrc, dest, &client.APIError{StatusCode: http.StatusNotFound, Message: "not found"})
+	summary := r.Finish()
+
+	assert.Equal(t, summary.Copied, 1)
+	assert.Equal(t, summary.Skipped, 1)
+	assert.Equal(t, summary.Failed, 1)
+	assert.Equal(t, summary.Bytes, int64(5))
+
+	events := []map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(line), &event))
+		if event["event"] != "progress" {
+			events = append(events, event)
+		}
+	}
+
+	eventNames := []string{}
+	for _, event := range events {
+		eventNames = append(eventNames, event["event"].(string))
+	}
+	assert.Equal(t, eventNames, []string{"plan", "file-start", "file-done", "file-skipped", "file-error", "summary"})
+
+	assert.Equal(t, events[0]["files_total"], float64(3))
+	assert.Equal(t, events[2]["destination"], "/home/music/time.txt")
+	assert.Equal(t, events[2]["bytes"], float64(5))
+	assert.Equal(t, events[2]["renamed"], false)
+	assert.NotContains(t, events[1], "renamed")
+	assert.Equal(t, events[4]["code"], "not_found")
+	assert.Equal(t, events[5]["files_copied"], float64(1))
+}
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, ErrorCode(&client.APIError{StatusCode: http.StatusUnauthorized}), "unauthorized")
+	assert.Equal(t, ErrorCode(&client.APIError{StatusCode: http.StatusForbidden}), "permission_denied")
+	assert.Equal(t, ErrorCode(&client.APIError{StatusCode: http.StatusTooManyRequests}), "rate_limited")
+	assert.Equal(t, ErrorCode(fmt.Errorf("reading: %w", &client.APIError{StatusCode: http.StatusNotFound})), "not_found")
+	assert.Equal(t, ErrorCode(fmt.Errorf("reading: %w", os.ErrNotExist)), "not_found")
+	assert.Equal(t, ErrorCode(&existsError{path: "/home/music/time.txt"}), "already_exists")
+	assert.Equal(t, ErrorCode(errors.New("something")), "unknown")
+}